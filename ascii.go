@@ -0,0 +1,125 @@
+package charconv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// asciiMask 用于逐字（8字节）判断数据是否全部为ASCII
+const asciiMask = 0x8080808080808080
+
+// asciiPrefix 返回p开头连续ASCII字节的长度
+func asciiPrefix(p []byte) int {
+	i := 0
+	for ; i+8 <= len(p); i += 8 {
+		if binary.LittleEndian.Uint64(p[i:])&asciiMask != 0 {
+			break
+		}
+	}
+	for ; i < len(p) && p[i] < 0x80; i++ {
+	}
+	return i
+}
+
+// asciiCompatibles 缓存各Encoding是否兼容ASCII
+var asciiCompatibles sync.Map
+
+var asciiBytes = func() []byte {
+	p := make([]byte, 0x80)
+	for i := range p {
+		p[i] = byte(i)
+	}
+	return p
+}()
+
+// asciiCompatible 判断e是否兼容ASCII，即0x00-0x7F在编码和解码时均保持不变，且不存在移位状态。
+// ISO-2022-JP、HZ-GB-2312、UTF-16等不满足此条件
+func asciiCompatible(e encoding.Encoding) bool {
+	if e == nil {
+		return false
+	}
+	if v, ok := asciiCompatibles.Load(e); ok {
+		return v.(bool)
+	}
+	decoded, err := e.NewDecoder().Bytes(asciiBytes)
+	compatible := err == nil && bytes.Equal(decoded, asciiBytes)
+	if compatible {
+		encoded, err := e.NewEncoder().Bytes(asciiBytes)
+		compatible = err == nil && bytes.Equal(encoded, asciiBytes)
+	}
+	asciiCompatibles.Store(e, compatible)
+	return compatible
+}
+
+// asciiTransformer 为兼容ASCII的字符集提供快速路径：直接复制ASCII字节，仅将非ASCII部分交给inner处理。
+// inner必须是无状态的，每次调用Transform后都停在字符边界上
+type asciiTransformer struct {
+	inner transform.Transformer
+	// decode 为true时inner是解码器
+	decode bool
+}
+
+func newASCIITransformer(inner transform.Transformer, decode bool) *asciiTransformer {
+	return &asciiTransformer{inner: inner, decode: decode}
+}
+
+func (a *asciiTransformer) Reset() {
+	a.inner.Reset()
+}
+
+func (a *asciiTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		n := asciiPrefix(src[nSrc:])
+		if n > 0 {
+			if n > len(dst)-nDst {
+				n = copy(dst[nDst:], src[nSrc:nSrc+len(dst)-nDst])
+				return nDst + n, nSrc + n, transform.ErrShortDst
+			}
+			copy(dst[nDst:], src[nSrc:nSrc+n])
+			nDst += n
+			nSrc += n
+			if nSrc == len(src) {
+				break
+			}
+		}
+
+		end := nSrc + a.spanEnd(src[nSrc:])
+		dn, sn, err := a.inner.Transform(dst[nDst:], src[nSrc:end], atEOF && end == len(src))
+		nDst += dn
+		nSrc += sn
+		if err == transform.ErrShortSrc && end < len(src) {
+			// 非ASCII部分以不完整的字符结尾，交给inner处理剩余的全部数据
+			dn, sn, err = a.inner.Transform(dst[nDst:], src[nSrc:], atEOF)
+			nDst += dn
+			nSrc += sn
+		}
+		if err != nil {
+			return nDst, nSrc, err
+		}
+	}
+	return nDst, nSrc, nil
+}
+
+// spanEnd 返回p开头非ASCII部分的长度。多字节字符集的尾字节可能落在ASCII范围内，
+// 因此解码时只有连续两个ASCII字节才能确定处于字符边界
+func (a *asciiTransformer) spanEnd(p []byte) int {
+	if !a.decode {
+		// UTF-8多字节序列中不含ASCII字节
+		for i, b := range p {
+			if b < 0x80 {
+				return i
+			}
+		}
+		return len(p)
+	}
+	for i := 1; i < len(p); i++ {
+		if p[i] < 0x80 && p[i-1] < 0x80 {
+			return i
+		}
+	}
+	return len(p)
+}
@@ -0,0 +1,151 @@
+package charconv
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"golang.org/x/text/transform"
+)
+
+var asciiTestCharsets = []string{GBK, GB18030, Big5, EUCJP, ShiftJIS, EUCKR, Windows1252, ISO88595, KOI8R}
+
+func TestASCIICompatible(t *testing.T) {
+	for _, charset := range asciiTestCharsets {
+		if !asciiCompatible(EncodingOf(charset)) {
+			t.Fatal(charset)
+		}
+	}
+	for _, charset := range []string{ISO2022JP, "HZGB2312", UTF16LE, UTF16, IBM037} {
+		if asciiCompatible(EncodingOf(charset)) {
+			t.Fatal(charset)
+		}
+	}
+}
+
+func TestASCIIPrefix(t *testing.T) {
+	p := []byte(strings.Repeat("a", 20) + "\x80abc")
+	for i := 0; i <= len(p); i++ {
+		want := i
+		if want > 20 {
+			want = 20
+		}
+		if n := asciiPrefix(p[:i]); n != want {
+			t.Fatal(i, n)
+		}
+	}
+}
+
+// randomMixed 生成以ASCII为主、夹杂任意非ASCII字节的数据
+func randomMixed(r *rand.Rand, n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		if r.Intn(4) == 0 {
+			p[i] = byte(0x80 + r.Intn(0x80))
+		} else {
+			p[i] = byte(0x20 + r.Intn(0x60))
+		}
+	}
+	return p
+}
+
+func TestASCIITransformerMatchesInner(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, charset := range asciiTestCharsets {
+		e := EncodingOf(charset)
+		c, err := NewConverter(WithSourceCharset(charset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 50; i++ {
+			src := randomMixed(r, 1+r.Intn(300))
+			want, _, _ := transform.Bytes(e.NewDecoder(), src)
+
+			got, _, err := c.ConvertBytes(src)
+			if err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("%s decode %q: %q != %q", charset, src, got, want)
+			}
+			buffer := MakeByteBuffer(0)
+			_, err = c.Convert(iotest.OneByteReader(bytes.NewReader(src)), buffer)
+			if err != nil || !bytes.Equal(buffer.Bytes(), want) {
+				t.Fatalf("%s stream decode %q: %q != %q", charset, src, buffer.Bytes(), want)
+			}
+
+			// 去掉替换字符后重新编码，结果应与直接使用编码器一致
+			text := bytes.ReplaceAll(want, []byte("\uFFFD"), nil)
+			wantEncoded, _, wantErr := transform.Bytes(e.NewEncoder(), text)
+			encoded, err := AppendEncode(nil, string(text), charset)
+			if (err == nil) != (wantErr == nil) || (err == nil && !bytes.Equal(encoded, wantEncoded)) {
+				t.Fatalf("%s encode %q: %q %v != %q %v", charset, text, encoded, err, wantEncoded, wantErr)
+			}
+		}
+	}
+}
+
+// mixedCorpus 模拟以ASCII为主的日志数据，约5%为中文
+var mixedCorpus = strings.Repeat("2024-01-02 15:04:05 INFO request handled path=/api/v1/items status=200 user=你好世界\n", 500)
+
+func BenchmarkDecodeMixed(b *testing.B) {
+	src, _ := EncodeStringToBytesWithCharset(mixedCorpus, 0, GBK)
+	dest := make([]byte, 0, 2*len(src))
+	b.Run("transformer", func(b *testing.B) {
+		decoder := DecoderOf(GBK)
+		b.SetBytes(int64(len(src)))
+		for i := 0; i < b.N; i++ {
+			decoder.Reset()
+			_, _, _ = transform.Append(decoder, dest[:0], src)
+		}
+	})
+	b.Run("fastpath", func(b *testing.B) {
+		b.SetBytes(int64(len(src)))
+		for i := 0; i < b.N; i++ {
+			_, _ = AppendDecode(dest[:0], src, GBK)
+		}
+	})
+}
+
+func BenchmarkEncodeMixed(b *testing.B) {
+	dest := make([]byte, 0, 2*len(mixedCorpus))
+	b.Run("transformer", func(b *testing.B) {
+		encoder := EncoderOf(GBK)
+		b.SetBytes(int64(len(mixedCorpus)))
+		for i := 0; i < b.N; i++ {
+			encoder.Reset()
+			_, _, _ = transform.Append(encoder, dest[:0], []byte(mixedCorpus))
+		}
+	})
+	// 关闭规范化，只比较快速路径本身
+	b.Run("fastpath", func(b *testing.B) {
+		c, _ := NewConverter(WithTargetCharset(GBK), WithNormalization(NormalizationNone))
+		b.SetBytes(int64(len(mixedCorpus)))
+		for i := 0; i < b.N; i++ {
+			_, _ = c.AppendString(dest[:0], mixedCorpus)
+		}
+	})
+	b.Run("AppendEncode", func(b *testing.B) {
+		b.SetBytes(int64(len(mixedCorpus)))
+		for i := 0; i < b.N; i++ {
+			_, _ = AppendEncode(dest[:0], mixedCorpus, GBK)
+		}
+	})
+}
+
+func BenchmarkConvertMixed(b *testing.B) {
+	src, _ := EncodeStringToBytesWithCharset(mixedCorpus, 0, GBK)
+	b.Run("transformer", func(b *testing.B) {
+		b.SetBytes(int64(len(src)))
+		for i := 0; i < b.N; i++ {
+			r := transform.NewReader(bytes.NewReader(src), transform.Chain(DecoderOf(GBK), EncoderOf(GB18030)))
+			_, _ = MakeByteBuffer(len(src)).ReadFrom(r)
+		}
+	})
+	b.Run("fastpath", func(b *testing.B) {
+		c, _ := NewConverter(WithSourceCharset(GBK), WithTargetCharset(GB18030))
+		b.SetBytes(int64(len(src)))
+		for i := 0; i < b.N; i++ {
+			_ = c.convert(bytes.NewReader(src), MakeByteBuffer(len(src)), nil)
+		}
+	})
+}
@@ -2,12 +2,14 @@ package charconv
 
 import (
 	"bytes"
-	"fmt"
+	"errors"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode/utf32"
 	"io"
 	"os"
 	"strings"
+	"unsafe"
 )
 
 // 中文
@@ -34,6 +36,9 @@ const (
 	UTF16   = "UTF-16"
 	UTF16BE = "UTF-16BE"
 	UTF16LE = "UTF-16LE"
+	UTF32   = "UTF-32"
+	UTF32BE = "UTF-32BE"
+	UTF32LE = "UTF-32LE"
 )
 
 // 其他字符集
@@ -92,6 +97,15 @@ const (
 var alias = map[string]string{
 	"HZGB2312": "HZ-GB-2312",
 	"hzgb2312": "HZ-GB-2312",
+	// github.com/saintfish/chardet检测结果中的名称
+	"GB-18030": GB18030,
+}
+
+// ianaindex不提供UTF-32，需要单独注册。UTF-32（未指定字节序）解码时根据BOM判断字节序，编码时写入BOM
+var utf32Encodings = map[string]encoding.Encoding{
+	UTF32:   utf32.UTF32(utf32.BigEndian, utf32.UseBOM),
+	UTF32BE: utf32.UTF32(utf32.BigEndian, utf32.IgnoreBOM),
+	UTF32LE: utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM),
 }
 
 // EncodingOf 获取charsetName对应Encoding对象
@@ -100,6 +114,9 @@ func EncodingOf(charsetName string) encoding.Encoding {
 	if ok {
 		charsetName = c
 	}
+	if en, ok := utf32Encodings[strings.ToUpper(charsetName)]; ok {
+		return en
+	}
 	en, err := ianaindex.MIB.Encoding(charsetName)
 	if err != nil {
 		return nil
@@ -141,9 +158,10 @@ func IsCharsetSupported(charset string) bool {
 	return EncodingOf(charset) != nil
 }
 
-func CopyTmpFileTo(tmp *os.File, destPath string, destFlag int) error {
+// CopyTmpFileTo 将临时文件tmp的全部内容拷贝到destPath并同步到磁盘，目标文件的关闭错误会一并返回
+func CopyTmpFileTo(tmp *os.File, destPath string, destFlag int) (err error) {
 	// 重置临时文件读取点
-	_, err := tmp.Seek(0, io.SeekStart)
+	_, err = tmp.Seek(0, io.SeekStart)
 	if err != nil {
 		return err
 	}
@@ -153,30 +171,55 @@ func CopyTmpFileTo(tmp *os.File, destPath string, destFlag int) error {
 	if err != nil {
 		return err
 	}
-	defer CloseQuietly(destFile)
+	defer func() {
+		err = errors.Join(err, destFile.Close())
+	}()
 
 	// 将临时文件拷贝到目标文件中
 	_, err = io.Copy(destFile, tmp)
 	if err != nil {
 		return err
 	}
-	return nil
+	return destFile.Sync()
 }
 
+// writeViaTmpFile 先通过write将结果写入tmpDir下的临时文件，再将临时文件拷贝到destPath。tmpDir为空时使用os.TempDir()。
+// 写入、同步、拷贝及关闭目标文件过程中的错误均会返回，多个错误通过errors.Join合并
+func writeViaTmpFile(op string, tmpDir string, destPath string, destFlag int, write func(w io.Writer) error) error {
+	tmpFile, err := os.CreateTemp(tmpDir, "*")
+	if err != nil {
+		return opError(op, destPath, err)
+	}
+	defer RemoveQuietly(tmpFile)
+
+	err = write(tmpFile)
+	if err != nil {
+		return opError(op, destPath, err)
+	}
+	err = tmpFile.Sync()
+	if err != nil {
+		return opError(op, destPath, err)
+	}
+	return opError(op, destPath, CopyTmpFileTo(tmpFile, destPath, destFlag))
+}
+
+// CloseQuietly 关闭文件，错误仅通过SetLogger设置的日志记录器输出。
+// 只应用于只读文件或即将被删除的临时文件
 func CloseQuietly(file *os.File) {
 	err := file.Close()
 	if err != nil {
-		fmt.Println("error on closing file:", err)
+		logError("error on closing file", "file", file.Name(), "error", err)
 		return
 	}
 }
 
+// RemoveQuietly 关闭并删除文件，错误仅通过SetLogger设置的日志记录器输出
 func RemoveQuietly(file *os.File) {
 	filename := file.Name()
 	CloseQuietly(file)
 	err := os.Remove(filename)
 	if err != nil {
-		fmt.Println("error on remove file:", err)
+		logError("error on remove file", "file", filename, "error", err)
 		return
 	}
 }
@@ -193,3 +236,16 @@ func MakeByteBuffer(initBufferSize int) *bytes.Buffer {
 func charsetEquals(charsetA, charsetB string) bool {
 	return strings.ToUpper(charsetA) == strings.ToUpper(charsetB)
 }
+
+// unsafeBytes 返回与s共享内存的[]byte，调用者不得修改其内容
+func unsafeBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+// unsafeString 返回与b共享内存的字符串，调用者之后不得再修改b
+func unsafeString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
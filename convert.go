@@ -2,50 +2,47 @@ package charconv
 
 import (
 	"golang.org/x/text/encoding"
-	"golang.org/x/text/transform"
 	"io"
-	"os"
 )
 
-func Convert(src io.Reader, dest io.Writer, decoder *encoding.Decoder, encoder *encoding.Encoder) error {
-	decoderReader := transform.NewReader(src, decoder)
-	encoderReader := transform.NewReader(decoderReader, encoder)
-	_, err := io.Copy(dest, encoderReader)
-	return err
+// codecConverter 创建使用decoder和encoder的Converter，供Convert函数使用
+func codecConverter(decoder *encoding.Decoder, encoder *encoding.Encoder) *Converter {
+	c, _ := NewConverter(WithDecoder(decoder), WithEncoder(encoder))
+	return c
 }
 
-func ConvertBetweenCharsets(src io.Reader, srcCharset string, dest io.Writer, destCharset string) error {
+// charsetConverter 创建从srcCharset转换到destCharset的Converter，两者相同或不受支持时返回错误
+func charsetConverter(srcCharset, destCharset string) (*Converter, error) {
 	if charsetEquals(srcCharset, destCharset) {
-		return unsupportedConversion(srcCharset, destCharset)
+		return nil, opError(OpConvert, "", unsupportedConversion(srcCharset, destCharset))
 	}
+	return NewConverter(WithSourceCharset(srcCharset), WithTargetCharset(destCharset))
+}
 
-	if charsetEquals(srcCharset, UTF8) {
-		encoder := EncoderOf(destCharset)
-		if encoder == nil {
-			return unsupported(destCharset)
-		}
-		return Encode(src, dest, encoder)
-	}
+func Convert(src io.Reader, dest io.Writer, decoder *encoding.Decoder, encoder *encoding.Encoder) error {
+	return codecConverter(decoder, encoder).convert(src, dest, nil)
+}
 
-	if charsetEquals(destCharset, UTF8) {
-		decoder := DecoderOf(srcCharset)
-		if decoder == nil {
-			return unsupported(srcCharset)
-		}
-		return Decode(src, dest, decoder)
-	}
+// ConvertWithStats 与Convert相同，同时返回转换过程的统计信息
+func ConvertWithStats(src io.Reader, dest io.Writer, decoder *encoding.Decoder, encoder *encoding.Encoder) (Stats, error) {
+	return codecConverter(decoder, encoder).Convert(src, dest)
+}
 
-	encoder := EncoderOf(destCharset)
-	if encoder == nil {
-		return unsupported(destCharset)
+func ConvertBetweenCharsets(src io.Reader, srcCharset string, dest io.Writer, destCharset string) error {
+	c, err := charsetConverter(srcCharset, destCharset)
+	if err != nil {
+		return err
 	}
+	return c.convert(src, dest, nil)
+}
 
-	decoder := DecoderOf(srcCharset)
-	if decoder == nil {
-		return unsupported(srcCharset)
+// ConvertBetweenCharsetsWithStats 与ConvertBetweenCharsets相同，同时返回转换过程的统计信息
+func ConvertBetweenCharsetsWithStats(src io.Reader, srcCharset string, dest io.Writer, destCharset string) (Stats, error) {
+	c, err := charsetConverter(srcCharset, destCharset)
+	if err != nil {
+		return Stats{}, err
 	}
-
-	return Convert(src, dest, decoder, encoder)
+	return c.Convert(src, dest)
 }
 
 func ConvertFileBetweenCharsets(
@@ -55,73 +52,24 @@ func ConvertFileBetweenCharsets(
 	destFileCharset string,
 	destFileFlag int,
 ) error {
-	if charsetEquals(srcFileCharset, destFileCharset) {
-		return unsupportedConversion(srcFileCharset, destFileCharset)
-	}
-
-	srcFile, err := os.Open(srcFilePath)
+	c, err := charsetConverter(srcFileCharset, destFileCharset)
 	if err != nil {
-		return err
-	}
-	defer CloseQuietly(srcFile)
-
-	tmpFile, err := MakeTempFile()
-	if err != nil {
-		return err
-	}
-	defer RemoveQuietly(tmpFile)
-
-	if charsetEquals(srcFileCharset, UTF8) {
-		encoder := EncoderOf(destFileCharset)
-		if encoder == nil {
-			return unsupported(destFileCharset)
-		}
-		err = Encode(srcFile, tmpFile, encoder)
-		if err != nil {
-			return err
-		}
-		err = tmpFile.Sync()
-		if err != nil {
-			return err
-		}
-		return CopyTmpFileTo(tmpFile, destFilePath, destFileFlag)
-
-	}
-
-	if charsetEquals(destFileCharset, UTF8) {
-		decoder := DecoderOf(srcFileCharset)
-		if decoder == nil {
-			return unsupported(srcFileCharset)
-		}
-		err = Decode(srcFile, tmpFile, decoder)
-		if err != nil {
-			return err
-		}
-		err = tmpFile.Sync()
-		if err != nil {
-			return err
-		}
-		return CopyTmpFileTo(tmpFile, destFilePath, destFileFlag)
-	}
-
-	encoder := EncoderOf(destFileCharset)
-	if encoder == nil {
-		return unsupported(destFileCharset)
-	}
-
-	decoder := DecoderOf(srcFileCharset)
-	if decoder == nil {
-		return unsupported(srcFileCharset)
+		return opError(OpConvert, srcFilePath, err)
 	}
+	return c.convertFileToFile(srcFilePath, destFilePath, destFileFlag, nil)
+}
 
-	err = Convert(srcFile, tmpFile, decoder, encoder)
-	if err != nil {
-		return err
-	}
-	err = tmpFile.Sync()
+// ConvertFileBetweenCharsetsWithStats 与ConvertFileBetweenCharsets相同，同时返回转换过程的统计信息
+func ConvertFileBetweenCharsetsWithStats(
+	srcFilePath string,
+	srcFileCharset string,
+	destFilePath string,
+	destFileCharset string,
+	destFileFlag int,
+) (Stats, error) {
+	c, err := charsetConverter(srcFileCharset, destFileCharset)
 	if err != nil {
-		return err
+		return Stats{}, opError(OpConvert, srcFilePath, err)
 	}
-	return CopyTmpFileTo(tmpFile, destFilePath, destFileFlag)
-
+	return c.ConvertFileToFile(srcFilePath, destFilePath, destFileFlag)
 }
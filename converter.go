@@ -0,0 +1,839 @@
+package charconv
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sync"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// AutoDetect 作为源字符集时，表示根据源数据开头的内容自动检测其编码
+const AutoDetect = "auto"
+
+// DefaultDetectBytes 自动检测编码时默认读取的字节数
+const DefaultDetectBytes = 4096
+
+// BOMPolicy BOM处理策略
+type BOMPolicy int
+
+const (
+	// BOMKeep 保持原样（默认）
+	BOMKeep BOMPolicy = iota
+	// BOMStrip 去除源数据开头的BOM
+	BOMStrip
+	// BOMAdd 去除源数据开头的BOM，并在目标字符集为UTF-8、UTF-16BE或UTF-16LE时在输出开头写入BOM
+	BOMAdd
+)
+
+// NewlinePolicy 换行符处理策略
+type NewlinePolicy int
+
+const (
+	// NewlinePreserve 保持原样（默认）
+	NewlinePreserve NewlinePolicy = iota
+	// NewlineToLF 将所有换行符（\n、\r\n、\r、U+0085、U+2028、U+2029）转换为\n
+	NewlineToLF
+	// NewlineToCRLF 将所有换行符转换为\r\n
+	NewlineToCRLF
+	// NewlineToCR 将所有换行符转换为\r
+	NewlineToCR
+	// NewlineDetect 保持原样，但出现与第一个换行符风格不同的换行符时转换失败并返回ErrMixedNewlineStyle
+	NewlineDetect
+)
+
+// Converter 按照选项在字符集之间转换数据。
+// 源字符集与目标字符集默认均为UTF-8，源字符集为UTF-8时跳过解码，目标字符集为UTF-8时跳过编码。
+// Converter创建后不可修改，内部复用Transformer及缓冲区，可以被多个goroutine同时使用（使用WithDecoder、WithEncoder时除外）
+type Converter struct {
+	srcCharset    string
+	destCharset   string
+	decoder       *encoding.Decoder
+	encoder       *encoding.Encoder
+	detectBytes   int
+	policy        Policy
+	handler       Handler
+	bom           BOMPolicy
+	newline       NewlinePolicy
+	normalization NormalizationForm
+	sanitize      sanitizeRules
+	offsets       *OffsetMap
+	bufferSize    int
+	capacity      int
+	parallelism   int
+	chunkSize     int
+	mmap          bool
+	tempDir       string
+	atomic        bool
+
+	maxInput     int64
+	maxOutput    int64
+	maxExpansion float64
+
+	op string
+
+	// pool 缓存state，使Converter可以被多个goroutine同时使用
+	pool sync.Pool
+}
+
+// Option Converter选项
+type Option func(c *Converter)
+
+// WithSourceCharset 设置源字符集，传入AutoDetect时自动检测源数据编码
+func WithSourceCharset(charset string) Option {
+	return func(c *Converter) {
+		c.srcCharset = charset
+	}
+}
+
+// WithTargetCharset 设置目标字符集
+func WithTargetCharset(charset string) Option {
+	return func(c *Converter) {
+		c.destCharset = charset
+	}
+}
+
+// WithDecoder 直接指定解码器，优先于WithSourceCharset。
+// 由于Decoder本身带有状态，使用该选项的Converter不能被并发使用
+func WithDecoder(decoder *encoding.Decoder) Option {
+	return func(c *Converter) {
+		c.decoder = decoder
+	}
+}
+
+// WithEncoder 直接指定编码器，优先于WithTargetCharset。
+// 由于Encoder本身带有状态，使用该选项的Converter不能被并发使用
+func WithEncoder(encoder *encoding.Encoder) Option {
+	return func(c *Converter) {
+		c.encoder = encoder
+	}
+}
+
+// WithDetectBytes 设置自动检测编码时读取的字节数
+func WithDetectBytes(n int) Option {
+	return func(c *Converter) {
+		if n > 0 {
+			c.detectBytes = n
+		}
+	}
+}
+
+// WithErrorPolicy 设置遇到非法字节序列或无法映射字符时的处理策略
+func WithErrorPolicy(policy Policy) Option {
+	return func(c *Converter) {
+		c.policy = policy
+	}
+}
+
+// WithHandler 设置遇到非法字节序列或无法映射字符时的回调
+func WithHandler(handler Handler) Option {
+	return func(c *Converter) {
+		c.handler = handler
+	}
+}
+
+// WithBOMPolicy 设置BOM处理策略
+func WithBOMPolicy(policy BOMPolicy) Option {
+	return func(c *Converter) {
+		c.bom = policy
+	}
+}
+
+// WithNewlinePolicy 设置换行符处理策略
+func WithNewlinePolicy(policy NewlinePolicy) Option {
+	return func(c *Converter) {
+		c.newline = policy
+	}
+}
+
+// WithBufferSize 设置拷贝数据时使用的缓冲区大小，同时作为输出到[]byte时的初始容量
+func WithBufferSize(size int) Option {
+	return func(c *Converter) {
+		if size > 0 {
+			c.bufferSize = size
+		}
+	}
+}
+
+// withCapacity 设置输出到[]byte时的初始容量，不影响缓冲区大小，供Encode、Decode系列函数的initBuffSize参数使用
+func withCapacity(size int) Option {
+	return func(c *Converter) {
+		if size > 0 {
+			c.capacity = size
+		}
+	}
+}
+
+// capacityHint 返回输出到[]byte时的初始容量，未设置时返回0
+func (c *Converter) capacityHint() int {
+	if c.capacity > 0 {
+		return c.capacity
+	}
+	return c.bufferSize
+}
+
+// withOp 设置错误中记录的操作名称，供Encode、Decode系列函数使用
+func withOp(op string) Option {
+	return func(c *Converter) {
+		c.op = op
+	}
+}
+
+// NewConverter 创建Converter，字符集不受支持时返回错误
+func NewConverter(opts ...Option) (*Converter, error) {
+	c := &Converter{
+		srcCharset:  UTF8,
+		destCharset: UTF8,
+		detectBytes: DefaultDetectBytes,
+		chunkSize:   DefaultChunkSize,
+		op:          OpConvert,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.decoder == nil && c.srcCharset != AutoDetect && !IsCharsetSupported(c.srcCharset) {
+		return nil, opError(c.op, "", unsupported(c.srcCharset))
+	}
+	if c.encoder == nil && !IsCharsetSupported(c.destCharset) {
+		return nil, opError(c.op, "", unsupported(c.destCharset))
+	}
+	return c, nil
+}
+
+// Convert 转换src并写入dest
+func (c *Converter) Convert(src io.Reader, dest io.Writer) (Stats, error) {
+	var stats Stats
+	err := c.convert(src, dest, &stats)
+	return stats, err
+}
+
+// ConvertBytes 转换src，返回转换结果
+func (c *Converter) ConvertBytes(src []byte) ([]byte, Stats, error) {
+	var stats Stats
+	dest, err := c.convertBytes(src, &stats)
+	return dest, stats, err
+}
+
+// ConvertString 转换src，以字符串形式返回转换结果
+func (c *Converter) ConvertString(src string) (string, Stats, error) {
+	var stats Stats
+	dest, err := c.convertBytes(unsafeBytes(src), &stats)
+	if err != nil {
+		return "", stats, err
+	}
+	return unsafeString(dest), stats, nil
+}
+
+// Append 转换src并将结果追加到dst末尾。dst剩余容量足以容纳转换结果时，转换过程不分配内存
+func (c *Converter) Append(dst, src []byte) ([]byte, error) {
+	dst, err := c.appendBytes(dst, src, nil)
+	return dst, opError(c.op, "", err)
+}
+
+// AppendString 与Append相同，src为字符串
+func (c *Converter) AppendString(dst []byte, src string) ([]byte, error) {
+	return c.Append(dst, unsafeBytes(src))
+}
+
+// ConvertFile 转换srcFilePath指向的文件并写入dest
+func (c *Converter) ConvertFile(srcFilePath string, dest io.Writer) (Stats, error) {
+	var stats Stats
+	err := c.convertFile(srcFilePath, dest, &stats)
+	return stats, err
+}
+
+// ConvertToFile 转换src并写入destFilePath指向的文件
+func (c *Converter) ConvertToFile(src io.Reader, destFilePath string, destFileFlag int) (Stats, error) {
+	var stats Stats
+	err := c.convertToFile(src, destFilePath, destFileFlag, &stats)
+	return stats, err
+}
+
+// ConvertFileToFile 转换srcFilePath指向的文件并写入destFilePath指向的文件
+func (c *Converter) ConvertFileToFile(srcFilePath string, destFilePath string, destFileFlag int) (Stats, error) {
+	var stats Stats
+	err := c.convertFileToFile(srcFilePath, destFilePath, destFileFlag, &stats)
+	return stats, err
+}
+
+func (c *Converter) convert(src io.Reader, dest io.Writer, stats *Stats) error {
+	return opError(c.op, "", c.run(src, dest, false, stats))
+}
+
+func (c *Converter) convertToBytes(src io.Reader, sizeHint int, stats *Stats) ([]byte, error) {
+	if size := c.capacityHint(); size > 0 {
+		sizeHint = size
+	}
+	buffer := MakeByteBuffer(sizeHint)
+	err := c.convert(src, buffer, stats)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c *Converter) convertBytes(src []byte, stats *Stats) ([]byte, error) {
+	size := c.capacityHint()
+	if size == 0 {
+		size = len(src) + len(src)/2
+	}
+	dest, err := c.appendBytes(make([]byte, 0, size), src, stats)
+	if err != nil {
+		return nil, opError(c.op, "", err)
+	}
+	return dest, nil
+}
+
+func (c *Converter) convertFile(srcFilePath string, dest io.Writer, stats *Stats) error {
+	srcFile, err := os.Open(srcFilePath)
+	if err != nil {
+		return opError(c.op, srcFilePath, err)
+	}
+	defer CloseQuietly(srcFile)
+	return opError(c.op, srcFilePath, c.runFile(srcFile, dest, stats))
+}
+
+func (c *Converter) convertFileToBytes(srcFilePath string, stats *Stats) ([]byte, error) {
+	if c.mmap {
+		srcFile, err := os.Open(srcFilePath)
+		if err != nil {
+			return nil, opError(c.op, srcFilePath, err)
+		}
+		defer CloseQuietly(srcFile)
+		if size, ok := regularFileSize(srcFile); ok && size <= math.MaxInt {
+			return c.convertMapped(srcFile, size, stats)
+		}
+	}
+
+	buffer := MakeByteBuffer(c.capacityHint())
+	err := c.convertFile(srcFilePath, buffer, stats)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c *Converter) convertToFile(src io.Reader, destFilePath string, destFileFlag int, stats *Stats) error {
+	srcFile, ok := src.(*os.File)
+	viaTmp := ok && sameFile(srcFile, destFilePath)
+	return c.writeTo(destFilePath, destFileFlag, viaTmp, func(w io.Writer) error {
+		return c.convert(src, w, stats)
+	})
+}
+
+func (c *Converter) convertFileToFile(srcFilePath string, destFilePath string, destFileFlag int, stats *Stats) error {
+	srcFile, err := os.Open(srcFilePath)
+	if err != nil {
+		return opError(c.op, srcFilePath, err)
+	}
+	defer CloseQuietly(srcFile)
+
+	if size, ok := regularFileSize(srcFile); ok && size <= SmallFileSize {
+		// 小文件在内存中完成转换后直接写入目标文件，转换完成前不会改动目标文件
+		dest, err := c.convertMapped(srcFile, size, stats)
+		if err != nil {
+			return opError(c.op, srcFilePath, err)
+		}
+		return c.writeTo(destFilePath, destFileFlag, false, func(w io.Writer) error {
+			_, err := w.Write(dest)
+			return err
+		})
+	}
+	// 目标文件即源文件时，只能先写入临时文件
+	viaTmp := sameFile(srcFile, destFilePath)
+	return c.writeTo(destFilePath, destFileFlag, viaTmp, func(w io.Writer) error {
+		return opError(c.op, srcFilePath, c.runFile(srcFile, w, stats))
+	})
+}
+
+// convertMapped 将源文件的全部内容读入（或映射到）内存后转换，返回转换结果
+func (c *Converter) convertMapped(srcFile *os.File, size int64, stats *Stats) ([]byte, error) {
+	data, release, err := c.readSource(srcFile, size)
+	if err != nil {
+		return nil, opError(c.op, srcFile.Name(), err)
+	}
+	defer release()
+	dest, err := c.convertBytes(data, stats)
+	return dest, opError(c.op, srcFile.Name(), err)
+}
+
+// runFile 转换已打开的源文件，按选项选择并行转换、内存映射或流式读取，返回未经包装的错误
+func (c *Converter) runFile(srcFile *os.File, dest io.Writer, stats *Stats) error {
+	if c.parallelizable(srcFile) {
+		return c.run(srcFile, dest, true, stats)
+	}
+	if size, ok := regularFileSize(srcFile); ok && size > 0 && c.mmap {
+		// 不能映射时回退到流式读取
+		if data, release, err := mapSource(srcFile, size); err == nil {
+			defer release()
+			return c.runBytes(data, dest, stats)
+		}
+	}
+	return c.run(srcFile, dest, false, stats)
+}
+
+// regularFileSize 返回普通文件f的大小，f不是普通文件时ok为false
+func regularFileSize(f *os.File) (size int64, ok bool) {
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return 0, false
+	}
+	return fi.Size(), true
+}
+
+// run 执行转换，parallel为true且源字符集可以安全切分时并行转换，返回未经包装的错误
+func (c *Converter) run(src io.Reader, dest io.Writer, parallel bool, stats *Stats) error {
+	if c.limited() {
+		lr := &limitedReader{r: src, c: c}
+		src = lr
+		dest = &limitedWriter{w: dest, c: c, in: &lr.n}
+	}
+	var cr *countingReader
+	var cw *countingWriter
+	if stats != nil {
+		cr = &countingReader{r: src}
+		cw = &countingWriter{w: dest}
+		src, dest = cr, cw
+	}
+
+	srcCharset := c.srcCharset
+	if c.decoder == nil && srcCharset == AutoDetect {
+		br := bufio.NewReaderSize(src, c.detectBytes)
+		head, err := br.Peek(c.detectBytes)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return err
+		}
+		srcCharset, err = detectCharset(head)
+		if err != nil {
+			return err
+		}
+		src = br
+		if stats != nil {
+			stats.DetectedCharset = srcCharset
+		}
+	}
+
+	var split splitFunc
+	if parallel {
+		split = splitterOf(srcCharset)
+	}
+	var err error
+	if split != nil {
+		err = c.runParallel(src, dest, srcCharset, split, stats)
+	} else {
+		st := c.getState(srcCharset)
+		defer c.putState(st)
+		srcBuf, dstBuf := st.buffers(c.bufferSize)
+		err = pump(dest, src, st.begin(stats), srcBuf, dstBuf)
+	}
+
+	if stats != nil {
+		stats.BytesIn = cr.n
+		stats.BytesOut = cw.n
+		fixBOMStats(stats, c.bomCharset(srcCharset), cr.head[:cr.nHead])
+	}
+	return err
+}
+
+// appendBytes 转换src并将结果追加到dst末尾，不经过io.Reader，返回未经包装的错误
+func (c *Converter) appendBytes(dst, src []byte, stats *Stats) ([]byte, error) {
+	if err := c.checkInput(int64(len(src))); err != nil {
+		return dst, err
+	}
+	srcCharset, err := c.sourceCharsetOf(src, stats)
+	if err != nil {
+		return dst, err
+	}
+
+	st := c.getState(srcCharset)
+	defer c.putState(st)
+	t := st.begin(stats)
+
+	start := len(dst)
+	max, kind := c.outputLimit(int64(len(src)))
+	if t == nil {
+		if max >= 0 && int64(len(src)) > max {
+			return dst, sizeLimit(kind, max)
+		}
+		dst = append(dst, src...)
+	} else {
+		var exceeded bool
+		dst, exceeded, err = appendTransform(dst, src, t, max)
+		if exceeded {
+			err = sizeLimit(kind, max)
+		}
+	}
+
+	if stats != nil {
+		stats.BytesIn = int64(len(src))
+		stats.BytesOut = int64(len(dst) - start)
+		fixBOMStats(stats, c.bomCharset(srcCharset), src)
+	}
+	return dst, err
+}
+
+// runBytes 转换内存中的src并写入dest，返回未经包装的错误
+func (c *Converter) runBytes(src []byte, dest io.Writer, stats *Stats) error {
+	if c.limited() {
+		in := int64(len(src))
+		if err := c.checkInput(in); err != nil {
+			return err
+		}
+		dest = &limitedWriter{w: dest, c: c, in: &in}
+	}
+	srcCharset, err := c.sourceCharsetOf(src, stats)
+	if err != nil {
+		return err
+	}
+
+	st := c.getState(srcCharset)
+	defer c.putState(st)
+	t := st.begin(stats)
+
+	var n int64
+	if t == nil {
+		var nw int
+		nw, err = dest.Write(src)
+		n = int64(nw)
+	} else {
+		_, dstBuf := st.buffers(c.bufferSize)
+		n, err = pumpBytes(dest, src, t, dstBuf)
+	}
+
+	if stats != nil {
+		stats.BytesIn = int64(len(src))
+		stats.BytesOut = n
+		fixBOMStats(stats, c.bomCharset(srcCharset), src)
+	}
+	return err
+}
+
+// sourceCharsetOf 返回转换src使用的源字符集，需要自动检测时根据src开头的数据检测
+func (c *Converter) sourceCharsetOf(src []byte, stats *Stats) (string, error) {
+	if c.decoder != nil || c.srcCharset != AutoDetect {
+		return c.srcCharset, nil
+	}
+	head := src
+	if len(head) > c.detectBytes {
+		head = head[:c.detectBytes]
+	}
+	srcCharset, err := detectCharset(head)
+	if err != nil {
+		return "", err
+	}
+	if stats != nil {
+		stats.DetectedCharset = srcCharset
+	}
+	return srcCharset, nil
+}
+
+// appendTransform 使用t转换src并追加到dst末尾，dst容量不足时自动扩容。
+// max不小于0时最多追加max字节，超出时停止转换并返回exceeded为true
+func appendTransform(dst, src []byte, t transform.Transformer, max int64) (_ []byte, exceeded bool, err error) {
+	start := len(dst)
+	for {
+		nDst, nSrc, err := t.Transform(dst[len(dst):cap(dst)], src, true)
+		dst = dst[:len(dst)+nDst]
+		src = src[nSrc:]
+		if max >= 0 && int64(len(dst)-start) > max {
+			return dst[:start+int(max)], true, nil
+		}
+		if err != transform.ErrShortDst {
+			return dst, false, err
+		}
+		grow := len(src)
+		if grow < minHandlerDst {
+			grow = minHandlerDst
+		}
+		if max >= 0 {
+			// 不为超出限制的输出分配内存
+			if room := int(max) - (len(dst) - start) + minHandlerDst; grow > room {
+				grow = room
+			}
+		}
+		dst = slices.Grow(dst, cap(dst)-len(dst)+grow)
+	}
+}
+
+// bomCharset 返回用于检测BOM的源字符集，直接指定解码器时无法得知源字符集
+func (c *Converter) bomCharset(srcCharset string) string {
+	if c.decoder != nil {
+		return ""
+	}
+	return srcCharset
+}
+
+// codecs 返回本次转换使用的解码器和编码器，不需要解码或编码时对应返回值为nil
+func (c *Converter) codecs(srcCharset string) (decoder, encoder transform.Transformer) {
+	if c.decoder != nil {
+		decoder = c.decoder
+	} else if !isUTF8(srcCharset) {
+		e := EncodingOf(srcCharset)
+		decoder = e.NewDecoder()
+		if asciiCompatible(e) {
+			decoder = newASCIITransformer(decoder, true)
+		}
+	}
+	if c.encoder != nil {
+		encoder = c.encoder
+	} else if !isUTF8(c.destCharset) {
+		e := EncodingOf(c.destCharset)
+		encoder = e.NewEncoder()
+		if asciiCompatible(e) {
+			encoder = newASCIITransformer(encoder, false)
+		}
+	}
+	return decoder, encoder
+}
+
+// isUTF8 判断charset是否为UTF-8
+func isUTF8(charset string) bool {
+	return charsetEquals(charset, UTF8) || EncodingOf(charset) == unicode.UTF8
+}
+
+// writesBOM 判断BOMAdd策略下是否需要为目标字符集写入BOM。
+// UTF-16、UTF-32（未指定字节序）的编码器本身会写入BOM，因此无需重复写入
+func writesBOM(destCharset string) bool {
+	return isUTF8(destCharset) || charsetEquals(destCharset, UTF16BE) || charsetEquals(destCharset, UTF16LE) ||
+		charsetEquals(destCharset, UTF32BE) || charsetEquals(destCharset, UTF32LE)
+}
+
+// detectCharset 根据数据开头的head检测编码，优先根据BOM判断
+func detectCharset(head []byte) (string, error) {
+	switch {
+	case len(head) == 0, bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return UTF8, nil
+	case bytes.HasPrefix(head, []byte{0x00, 0x00, 0xFE, 0xFF}):
+		return UTF32BE, nil
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE, 0x00, 0x00}):
+		// UTF-32LE的BOM以UTF-16LE的BOM开头，需要先判断
+		return UTF32LE, nil
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return UTF16BE, nil
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return UTF16LE, nil
+	}
+	result, err := GuessBest(head)
+	if err != nil {
+		return "", err
+	}
+	if !IsCharsetSupported(result.Charset) {
+		return "", opError(OpDetect, "", detectionFailed(unsupported(result.Charset)))
+	}
+	return result.Charset, nil
+}
+
+// bomTransformer 去除Unicode文本开头的BOM，add为true时在开头写入BOM
+type bomTransformer struct {
+	add     bool
+	stats   *Stats
+	started bool
+}
+
+func newBOMTransformer(add bool, stats *Stats) *bomTransformer {
+	return &bomTransformer{add: add, stats: stats}
+}
+
+func (b *bomTransformer) Reset() {
+	b.started = false
+}
+
+var utf8BOM = []byte("\uFEFF")
+
+func (b *bomTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	if !b.started {
+		if len(src) < len(utf8BOM) && !atEOF && bytes.HasPrefix(utf8BOM, src) {
+			return 0, 0, transform.ErrShortSrc
+		}
+		if b.add {
+			if len(dst) < len(utf8BOM) {
+				return 0, 0, transform.ErrShortDst
+			}
+			nDst = copy(dst, utf8BOM)
+		}
+		if bytes.HasPrefix(src, utf8BOM) {
+			nSrc = len(utf8BOM)
+			if b.stats != nil {
+				b.stats.BOMStripped = true
+			}
+		}
+		b.started = true
+	}
+	n := copy(dst[nDst:], src[nSrc:])
+	nDst += n
+	nSrc += n
+	if nSrc < len(src) {
+		err = transform.ErrShortDst
+	}
+	return nDst, nSrc, err
+}
+
+// newlineTransformer 在Unicode域中统一换行符，newline为nil时保持原样并检测是否混用多种换行符
+type newlineTransformer struct {
+	newline []byte
+	// first 检测到的第一个换行符的风格，offset为已处理的文本字节数
+	first  LineTerminator
+	offset int64
+	// origin 不为nil时，用于将文本中的偏移换算为源数据中的偏移。
+	// prune为true时其后没有编码器使用origin，由newlineTransformer丢弃已处理的记录
+	origin *offsetTrack
+	prune  bool
+}
+
+func newNewlineTransformer(policy NewlinePolicy) *newlineTransformer {
+	t := &newlineTransformer{}
+	switch policy {
+	case NewlineToLF:
+		t.newline = []byte("\n")
+	case NewlineToCRLF:
+		t.newline = []byte("\r\n")
+	case NewlineToCR:
+		t.newline = []byte("\r")
+	}
+	return t
+}
+
+func (t *newlineTransformer) Reset() {
+	t.first = TerminatorNone
+	t.offset = 0
+}
+
+// newlineLead 可能是换行符开头的字节：\n、\r，以及U+0085、U+2028、U+2029的首字节
+var newlineLead = [256]bool{'\n': true, '\r': true, 0xC2: true, 0xE2: true}
+
+func (t *newlineTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	defer func() {
+		t.offset += int64(nSrc)
+		if t.origin != nil && t.prune {
+			t.origin.discard(t.offset)
+		}
+	}()
+	for nSrc < len(src) {
+		i := nSrc
+		for i < len(src) && !newlineLead[src[i]] {
+			i++
+		}
+		n := copy(dst[nDst:], src[nSrc:i])
+		nDst += n
+		nSrc += n
+		if nSrc < i {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		if nSrc == len(src) {
+			break
+		}
+
+		size, term, short := newlineAt(src[nSrc:])
+		if short && !atEOF {
+			// 需要后续的字节才能判断是否为\r\n或完整的换行符
+			return nDst, nSrc, transform.ErrShortSrc
+		}
+		if term == TerminatorNone {
+			if nDst == len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			dst[nDst] = src[nSrc]
+			nDst++
+			nSrc++
+			continue
+		}
+		newline := t.newline
+		if newline == nil {
+			if t.first == TerminatorNone {
+				t.first = term
+			} else if term != t.first {
+				return nDst, nSrc, mixedNewlineStyle(t.sourceOffset(t.offset+int64(nSrc)), t.first, term)
+			}
+			newline = src[nSrc : nSrc+size]
+		}
+		if nDst+len(newline) > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += copy(dst[nDst:], newline)
+		nSrc += size
+	}
+	return nDst, nSrc, nil
+}
+
+// newlineAt 判断p是否以换行符开头，返回其长度及类型，不是换行符时返回TerminatorNone。
+// short为true表示p不完整，需要后续的字节才能确定（位于末尾的\r在数据结束时为\r）
+func newlineAt(p []byte) (size int, term LineTerminator, short bool) {
+	switch p[0] {
+	case '\n':
+		return 1, TerminatorLF, false
+	case '\r':
+		if len(p) < 2 {
+			return 1, TerminatorCR, true
+		}
+		if p[1] == '\n' {
+			return 2, TerminatorCRLF, false
+		}
+		return 1, TerminatorCR, false
+	case 0xC2:
+		if len(p) < 2 {
+			return 0, TerminatorNone, true
+		}
+		if p[1] == 0x85 {
+			return 2, TerminatorNEL, false
+		}
+	case 0xE2:
+		if len(p) < 2 || p[1] == 0x80 && len(p) < 3 {
+			return 0, TerminatorNone, true
+		}
+		if p[1] == 0x80 && p[2] == 0xA8 {
+			return 3, TerminatorLS, false
+		}
+		if p[1] == 0x80 && p[2] == 0xA9 {
+			return 3, TerminatorPS, false
+		}
+	}
+	return 0, TerminatorNone, false
+}
+
+// sourceOffset 将文本中的偏移换算为源数据中的偏移
+func (t *newlineTransformer) sourceOffset(off int64) int64 {
+	if t.origin != nil {
+		return t.origin.sourceOffset(off)
+	}
+	return off
+}
+
+// converterCache 按字符集缓存Converter，供按字符集名称调用的Append系列函数复用
+type converterCache struct {
+	mu         sync.RWMutex
+	converters map[string]*Converter
+	create     func(charset string) (*Converter, error)
+}
+
+func newConverterCache(create func(charset string) (*Converter, error)) *converterCache {
+	return &converterCache{converters: make(map[string]*Converter), create: create}
+}
+
+// get 返回charset对应的Converter，首次使用时创建
+func (cc *converterCache) get(charset string) (*Converter, error) {
+	cc.mu.RLock()
+	c, ok := cc.converters[charset]
+	cc.mu.RUnlock()
+	if ok {
+		return c, nil
+	}
+
+	c, err := cc.create(charset)
+	if err != nil {
+		return nil, err
+	}
+	cc.mu.Lock()
+	if cached, ok := cc.converters[charset]; ok {
+		c = cached
+	} else {
+		cc.converters[charset] = c
+	}
+	cc.mu.Unlock()
+	return c, nil
+}
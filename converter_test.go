@@ -0,0 +1,373 @@
+package charconv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+)
+
+func TestConverterConvertBytes(t *testing.T) {
+	c, err := NewConverter(WithSourceCharset(GBK), WithTargetCharset(EUCJP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, stats, err := c.ConvertBytes(gbkData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dest, eucjpData) {
+		t.Fatal(dest)
+	}
+	if stats.BytesIn != int64(len(gbkData)) || stats.BytesOut != int64(len(eucjpData)) || stats.Runes != 5 {
+		t.Fatal(stats)
+	}
+}
+
+func TestConverterUnsupported(t *testing.T) {
+	_, err := NewConverter(WithTargetCharset("no-such-charset"))
+	if err == nil {
+		t.Fatal("unsupported charset should be rejected")
+	}
+}
+
+func TestConverterAutoDetect(t *testing.T) {
+	src := []byte{0xFF, 0xFE, 'a', 0, 'b', 0}
+	c, err := NewConverter(WithSourceCharset(AutoDetect), WithBOMPolicy(BOMStrip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, stats, err := c.ConvertBytes(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dest) != "ab" {
+		t.Fatalf("%q", dest)
+	}
+	if stats.DetectedCharset != UTF16LE || !stats.BOMFound || !stats.BOMStripped {
+		t.Fatal(stats)
+	}
+}
+
+func TestConverterUTF32(t *testing.T) {
+	src := []byte{0xFF, 0xFE, 0, 0, 'a', 0, 0, 0, 0x2d, 0x4e, 0, 0}
+	c, err := NewConverter(WithSourceCharset(AutoDetect), WithTargetCharset(UTF32BE), WithBOMPolicy(BOMStrip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, stats, err := c.ConvertBytes(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dest) != "\x00\x00\x00a\x00\x00\x4e\x2d" {
+		t.Fatalf("%q", dest)
+	}
+	if stats.DetectedCharset != UTF32LE || !stats.BOMFound || !stats.BOMStripped {
+		t.Fatal(stats)
+	}
+}
+
+func TestConverterBOMAdd(t *testing.T) {
+	c, err := NewConverter(WithTargetCharset(UTF16LE), WithBOMPolicy(BOMAdd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, _, err := c.ConvertString("\uFEFFa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest != "\xFF\xFEa\x00" {
+		t.Fatalf("%q", dest)
+	}
+}
+
+func TestConverterNewlinePolicy(t *testing.T) {
+	src := "a\r\nb\rc\nd\r"
+	cases := map[NewlinePolicy]string{
+		NewlinePreserve: src,
+		NewlineToLF:     "a\nb\nc\nd\n",
+		NewlineToCRLF:   "a\r\nb\r\nc\r\nd\r\n",
+		NewlineToCR:     "a\rb\rc\rd\r",
+	}
+	for policy, want := range cases {
+		c, err := NewConverter(WithNewlinePolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dest := MakeByteBuffer(0)
+		_, err = c.Convert(iotest.OneByteReader(strings.NewReader(src)), dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dest.String() != want {
+			t.Fatalf("policy %d: %q", policy, dest.String())
+		}
+	}
+}
+
+func TestConverterNewlineDetect(t *testing.T) {
+	c, err := NewConverter(WithSourceCharset(GBK), WithNewlinePolicy(NewlineDetect))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := EncodeStringToBytesWithCharset("中文\r\n世界\r\n", 0, GBK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, _, err := c.ConvertBytes(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dest) != "中文\r\n世界\r\n" {
+		t.Fatalf("%q", dest)
+	}
+
+	src, err = EncodeStringToBytesWithCharset("中文\r\n世界\nabc", 0, GBK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Convert(iotest.OneByteReader(bytes.NewReader(src)), MakeByteBuffer(0))
+	var mixed ErrMixedNewlineStyle
+	if !errors.Is(err, ErrMixedNewlines) || !errors.As(err, &mixed) {
+		t.Fatal(err)
+	}
+	// 偏移为源数据中的偏移
+	if mixed.Offset != 10 || mixed.First != TerminatorCRLF || mixed.Found != TerminatorLF {
+		t.Fatal(mixed)
+	}
+
+	c, err = NewConverter(WithSourceCharset(UTF16LE), WithNewlinePolicy(NewlineDetect))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err = EncodeStringToBytesWithCharset("a\u2028\u4e2d\u2028b\u0085", 0, UTF16LE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = c.ConvertBytes(src)
+	if !errors.As(err, &mixed) || mixed.Offset != 10 || mixed.First != TerminatorLS || mixed.Found != TerminatorNEL {
+		t.Fatal(err)
+	}
+}
+
+func TestConverterNewlineUnicode(t *testing.T) {
+	c, err := NewConverter(WithNewlinePolicy(NewlineToLF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "a\u0085b\u2028c\u2029d\r\ne\u20ac\u2020\u0080\r"
+	dest := MakeByteBuffer(0)
+	if _, err = c.Convert(iotest.OneByteReader(strings.NewReader(src)), dest); err != nil {
+		t.Fatal(err)
+	}
+	if dest.String() != "a\nb\nc\nd\ne\u20ac\u2020\u0080\n" {
+		t.Fatalf("%q", dest.String())
+	}
+}
+
+func TestConverterNewlineUTF16(t *testing.T) {
+	src, err := EncodeStringToBytesWithCharset("a\r\n中\r\n", 0, UTF16LE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewConverter(WithSourceCharset(UTF16LE), WithTargetCharset(UTF16BE), WithNewlinePolicy(NewlineToLF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, _, err := c.ConvertBytes(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := EncodeStringToBytesWithCharset("a\n中\n", 0, UTF16BE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dest, want) {
+		t.Fatal(dest)
+	}
+}
+
+func TestConverterErrorPolicy(t *testing.T) {
+	c, err := NewConverter(WithTargetCharset(ISO88591), WithErrorPolicy(PolicyReplace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, stats, err := c.ConvertString("a你b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest != "a\x1ab" || stats.Unmappable != 1 {
+		t.Fatalf("%q %v", dest, stats)
+	}
+
+	// PolicyDefault下无法映射的字符导致转换失败，不计入Unmappable
+	c, err = NewConverter(WithTargetCharset(ISO88591))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, stats, err = c.ConvertString("a你b"); !errors.Is(err, ErrUnmappable) || stats.Unmappable != 0 {
+		t.Fatal(stats, err)
+	}
+}
+
+func TestConverterFileToFile(t *testing.T) {
+	c, err := NewConverter(WithSourceCharset(GBK), WithNewlinePolicy(NewlineToLF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := "./test/out_utf8.txt"
+	stats, err := c.ConvertFileToFile("./test/test_gbk.txt", dest, CreateOrTrunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	destFile, err := os.Open(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseQuietly(destFile)
+	destBytes, err := io.ReadAll(destFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(destBytes, utf8Data) || stats.BytesOut != int64(len(utf8Data)) {
+		t.Fatal(destBytes, stats)
+	}
+}
+
+func TestConverterAppendString(t *testing.T) {
+	c, err := NewConverter(WithTargetCharset(GBK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := make([]byte, 0, 64)
+	dst, err = c.AppendString(dst, utf8String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst, gbkData) {
+		t.Fatal(dst)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		dst, err = c.AppendString(dst[:0], utf8String)
+	})
+	if err != nil || allocs != 0 && !raceEnabled {
+		t.Fatal(allocs, err)
+	}
+
+	// 容量不足时自动扩容
+	dst, err = c.AppendString([]byte("x"), strings.Repeat(utf8String, 100))
+	if err != nil || len(dst) != 1+len(gbkData)*100 {
+		t.Fatal(len(dst), err)
+	}
+}
+
+func TestConverterConcurrent(t *testing.T) {
+	c, err := NewConverter(WithSourceCharset(GBK), WithTargetCharset(EUCJP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := bytes.Repeat(gbkData, 2000)
+	want := bytes.Repeat(eucjpData, 2000)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				var dest []byte
+				var err error
+				if (i+j)%2 == 0 {
+					dest, err = c.Append(nil, src)
+				} else {
+					buffer := MakeByteBuffer(0)
+					_, err = c.Convert(bytes.NewReader(src), buffer)
+					dest = buffer.Bytes()
+				}
+				if err == nil && !bytes.Equal(dest, want) {
+					err = io.ErrUnexpectedEOF
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkConverterAppendString(b *testing.B) {
+	c, _ := NewConverter(WithTargetCharset(GBK))
+	dst := make([]byte, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dst, _ = c.AppendString(dst[:0], utf8String)
+	}
+}
+
+func BenchmarkEncodeStringToBytes(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = EncodeStringToBytesWithCharset(utf8String, 0, GBK)
+	}
+}
+
+func TestConverterMmap(t *testing.T) {
+	c, err := NewConverter(WithSourceCharset(GBK), WithMmap(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer := MakeByteBuffer(0)
+	stats, err := c.ConvertFile("./test/test_gbk.txt", buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffer.Bytes(), utf8Data) || stats.BytesIn != int64(len(gbkData)) || stats.BytesOut != int64(len(utf8Data)) {
+		t.Fatal(buffer.Bytes(), stats)
+	}
+
+	dest, err := c.convertFileToBytes("./test/test_gbk.txt", nil)
+	if err != nil || !bytes.Equal(dest, utf8Data) {
+		t.Fatal(dest, err)
+	}
+
+	// 不能映射的文件（/proc中的文件大小为0）回退到流式读取
+	if _, err = os.Stat("/proc/self/status"); err == nil {
+		buffer.Reset()
+		if _, err = c.ConvertFile("/proc/self/status", buffer); err != nil || buffer.Len() == 0 {
+			t.Fatal(buffer.Len(), err)
+		}
+	}
+}
+
+func TestConverterFileToFileInPlace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in_place.txt")
+	if err := os.WriteFile(path, gbkData, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, mmap := range []bool{false, true} {
+		c, err := NewConverter(WithSourceCharset(GBK), WithTargetCharset(GB18030), WithMmap(mmap))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.ConvertFileToFile(path, path, CreateOrTrunc); err != nil {
+			t.Fatal(err)
+		}
+		dest, err := os.ReadFile(path)
+		if err != nil || !bytes.Equal(dest, gbkData) {
+			t.Fatal(dest, err)
+		}
+	}
+}
@@ -3,26 +3,39 @@ package charconv
 import (
 	"bytes"
 	"golang.org/x/text/encoding"
-	"golang.org/x/text/transform"
 	"io"
-	"os"
 )
 
+// decoderConverter 创建使用decoder的Converter，供Decode系列函数使用
+func decoderConverter(decoder *encoding.Decoder, initBuffSize int) *Converter {
+	c, _ := NewConverter(withOp(OpDecode), WithDecoder(decoder), withCapacity(initBuffSize))
+	return c
+}
+
+// charsetDecoderConverter 创建从srcCharset解码的Converter，字符集不受支持时返回错误
+func charsetDecoderConverter(srcCharset string, initBuffSize int) (*Converter, error) {
+	return NewConverter(withOp(OpDecode), WithSourceCharset(srcCharset), withCapacity(initBuffSize))
+}
+
+var decodeConverters = newConverterCache(func(charset string) (*Converter, error) {
+	return charsetDecoderConverter(charset, 0)
+})
+
 func Decode(src io.Reader, dest io.Writer, decoder *encoding.Decoder) error {
-	srcReader := transform.NewReader(src, decoder)
-	_, err := io.Copy(dest, srcReader)
-	if err != nil {
-		return err
-	}
-	return nil
+	return decoderConverter(decoder, 0).convert(src, dest, nil)
+}
+
+// DecodeWithStats 与Decode相同，同时返回转换过程的统计信息
+func DecodeWithStats(src io.Reader, dest io.Writer, decoder *encoding.Decoder) (Stats, error) {
+	return decoderConverter(decoder, 0).Convert(src, dest)
 }
 
 func DecodeWithCharset(src io.Reader, dest io.Writer, srcCharset string) error {
-	decoder := DecoderOf(srcCharset)
-	if decoder == nil {
-		return unsupported(srcCharset)
+	c, err := charsetDecoderConverter(srcCharset, 0)
+	if err != nil {
+		return err
 	}
-	return Decode(src, dest, decoder)
+	return c.convert(src, dest, nil)
 }
 
 func DecodeBytes(src []byte, dest io.Writer, decoder *encoding.Decoder) error {
@@ -30,108 +43,77 @@ func DecodeBytes(src []byte, dest io.Writer, decoder *encoding.Decoder) error {
 }
 
 func DecodeBytesWithCharset(src []byte, dest io.Writer, srcCharset string) error {
-	decoder := DecoderOf(srcCharset)
-	if decoder == nil {
-		return unsupported(srcCharset)
-	}
 	return DecodeWithCharset(bytes.NewReader(src), dest, srcCharset)
 }
 
 func DecodeToBytes(src io.Reader, initBuffSize int, decoder *encoding.Decoder) ([]byte, error) {
-	buffer := MakeByteBuffer(initBuffSize)
-	err := Decode(src, buffer, decoder)
-	if err != nil {
-		return nil, err
-	}
-	return buffer.Bytes(), nil
+	return decoderConverter(decoder, initBuffSize).convertToBytes(src, 0, nil)
 }
 
 func DecodeToBytesWithCharset(src io.Reader, initBuffSize int, srcCharset string) ([]byte, error) {
-	decoder := DecoderOf(srcCharset)
-	if decoder == nil {
-		return nil, unsupported(srcCharset)
+	c, err := charsetDecoderConverter(srcCharset, initBuffSize)
+	if err != nil {
+		return nil, err
 	}
-	return DecodeToBytes(src, initBuffSize, decoder)
+	return c.convertToBytes(src, 0, nil)
 }
 
 func DecodeBytesToBytes(src []byte, initBuffSize int, decoder *encoding.Decoder) ([]byte, error) {
-	return DecodeToBytes(bytes.NewReader(src), initBuffSize, decoder)
+	return decoderConverter(decoder, initBuffSize).convertBytes(src, nil)
 }
 
 func DecodeBytesToBytesWithCharset(src []byte, initBuffSize int, srcCharset string) ([]byte, error) {
-	decoder := DecoderOf(srcCharset)
-	if decoder == nil {
-		return nil, unsupported(srcCharset)
+	c, err := charsetDecoderConverter(srcCharset, initBuffSize)
+	if err != nil {
+		return nil, err
 	}
-	return DecodeBytesToBytes(src, initBuffSize, decoder)
+	return c.convertBytes(src, nil)
 }
 
-func DecodeFile(srcFilePath string, dest io.Writer, decoder *encoding.Decoder) error {
-	srcFile, err := os.Open(srcFilePath)
+// AppendDecode 将srcCharset编码的src解码为UTF-8并追加到dst末尾，返回追加后的切片。
+// dst剩余容量足以容纳解码结果时不分配内存
+func AppendDecode(dst, src []byte, srcCharset string) ([]byte, error) {
+	c, err := decodeConverters.get(srcCharset)
 	if err != nil {
-		return err
+		return dst, err
 	}
-	defer CloseQuietly(srcFile)
-	return Decode(srcFile, dest, decoder)
+	return c.Append(dst, src)
+}
+
+func DecodeFile(srcFilePath string, dest io.Writer, decoder *encoding.Decoder) error {
+	return decoderConverter(decoder, 0).convertFile(srcFilePath, dest, nil)
 }
 
 func DecodeFileWithCharset(srcFilePath string, dest io.Writer, srcCharset string) error {
-	decoder := DecoderOf(srcCharset)
-	if decoder == nil {
-		return unsupported(srcCharset)
+	c, err := charsetDecoderConverter(srcCharset, 0)
+	if err != nil {
+		return opError(OpDecode, srcFilePath, err)
 	}
-	return DecodeFile(srcFilePath, dest, decoder)
+	return c.convertFile(srcFilePath, dest, nil)
 }
 
 func DecodeFileToFile(srcFilePath string, destFilePath string, destFileFlag int, decoder *encoding.Decoder) error {
-	tmpFile, err := MakeTempFile()
-	if err != nil {
-		return err
-	}
-	defer RemoveQuietly(tmpFile)
-	err = DecodeFile(srcFilePath, tmpFile, decoder)
-	if err != nil {
-		return err
-	}
-	err = tmpFile.Sync()
-	if err != nil {
-		return err
-	}
-	return CopyTmpFileTo(tmpFile, destFilePath, destFileFlag)
+	return decoderConverter(decoder, 0).convertFileToFile(srcFilePath, destFilePath, destFileFlag, nil)
 }
 
 func DecodeFileToFileWithCharset(srcFilePath string, destFilePath string, destFileFlag int, srcCharset string) error {
-	decoder := DecoderOf(srcCharset)
-	if decoder == nil {
-		return unsupported(srcCharset)
+	c, err := charsetDecoderConverter(srcCharset, 0)
+	if err != nil {
+		return opError(OpDecode, srcFilePath, err)
 	}
-	return DecodeFileToFile(srcFilePath, destFilePath, destFileFlag, decoder)
+	return c.convertFileToFile(srcFilePath, destFilePath, destFileFlag, nil)
 }
 
 func DecodeToFile(src io.Reader, destFilePath string, destFileFlag int, decoder *encoding.Decoder) error {
-	tmpFile, err := MakeTempFile()
-	if err != nil {
-		return err
-	}
-	defer RemoveQuietly(tmpFile)
-	err = Decode(src, tmpFile, decoder)
-	if err != nil {
-		return err
-	}
-	err = tmpFile.Sync()
-	if err != nil {
-		return err
-	}
-	return CopyTmpFileTo(tmpFile, destFilePath, destFileFlag)
+	return decoderConverter(decoder, 0).convertToFile(src, destFilePath, destFileFlag, nil)
 }
 
 func DecodeToFileWithCharset(src io.Reader, destFilePath string, destFileFlag int, srcCharset string) error {
-	decoder := DecoderOf(srcCharset)
-	if decoder == nil {
-		return unsupported(srcCharset)
+	c, err := charsetDecoderConverter(srcCharset, 0)
+	if err != nil {
+		return opError(OpDecode, destFilePath, err)
 	}
-	return DecodeToFile(src, destFilePath, destFileFlag, decoder)
-
+	return c.convertToFile(src, destFilePath, destFileFlag, nil)
 }
 
 func DecodeBytesToFile(src []byte, destFilePath string, destFileFlag int, decoder *encoding.Decoder) error {
@@ -139,9 +121,5 @@ func DecodeBytesToFile(src []byte, destFilePath string, destFileFlag int, decode
 }
 
 func DecodeBytesToFileWithCharset(src []byte, destFilePath string, destFileFlag int, srcCharset string) error {
-	decoder := DecoderOf(srcCharset)
-	if decoder == nil {
-		return unsupported(srcCharset)
-	}
-	return DecodeBytesToFile(src, destFilePath, destFileFlag, decoder)
+	return DecodeToFileWithCharset(bytes.NewReader(src), destFilePath, destFileFlag, srcCharset)
 }
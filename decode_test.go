@@ -55,6 +55,39 @@ func TestDecodeBytesToBytesWithCharset(t *testing.T) {
 	}
 }
 
+func TestAppendDecode(t *testing.T) {
+	dest, err := AppendDecode([]byte("utf8:"), gbkData, GBK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(dest, append([]byte("utf8:"), utf8Data...)) != 0 {
+		t.FailNow()
+	}
+
+	dest = make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		dest, err = AppendDecode(dest[:0], gbkData, GBK)
+	})
+	if err != nil || allocs != 0 && !raceEnabled {
+		t.Fatal(allocs, err)
+	}
+}
+
+func BenchmarkAppendDecode(b *testing.B) {
+	dest := make([]byte, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dest, _ = AppendDecode(dest[:0], gbkData, GBK)
+	}
+}
+
+func BenchmarkDecodeBytesToBytes(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = DecodeBytesToBytesWithCharset(gbkData, 0, GBK)
+	}
+}
+
 func TestDecodeFileToFileWithCharset(t *testing.T) {
 	src := "./test/test_gbk.txt"
 	dest := "./test/out_utf8.txt"
@@ -143,3 +176,18 @@ func TestDecodeToFileWithCharset(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestDecodeInitBuffSize(t *testing.T) {
+	// initBuffSize只作为输出的初始容量，不影响流式转换的缓冲区大小
+	c, err := charsetDecoderConverter(GBK, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.bufferSize != 0 || c.capacityHint() != 1<<20 {
+		t.Fatal(c.bufferSize, c.capacityHint())
+	}
+	dest, err := DecodeBytesToBytesWithCharset(gbkData, 1<<20, GBK)
+	if err != nil || !bytes.Equal(dest, utf8Data) || cap(dest) < 1<<20 {
+		t.Fatal(len(dest), cap(dest), err)
+	}
+}
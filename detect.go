@@ -2,6 +2,7 @@ package charconv
 
 import (
 	"errors"
+	"fmt"
 	"github.com/saintfish/chardet"
 	"io"
 	"os"
@@ -12,7 +13,7 @@ func GuessBest(data []byte) (result *chardet.Result, err error) {
 	detector := chardet.NewTextDetector()
 	best, err := detector.DetectBest(data)
 	if err != nil {
-		return nil, err
+		return nil, opError(OpDetect, "", detectionFailed(err))
 	}
 	return best, nil
 }
@@ -22,12 +23,12 @@ func GuessBestOf(src io.Reader, bytesToDetect int) (result *chardet.Result, err
 	buffer := make([]byte, bytesToDetect)
 	_, err = io.ReadFull(src, buffer)
 	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
-		return nil, err
+		return nil, opError(OpDetect, "", err)
 	}
 	detector := chardet.NewTextDetector()
 	best, err := detector.DetectBest(buffer)
 	if err != nil {
-		return nil, err
+		return nil, opError(OpDetect, "", detectionFailed(err))
 	}
 	return best, nil
 }
@@ -36,8 +37,14 @@ func GuessBestOf(src io.Reader, bytesToDetect int) (result *chardet.Result, err
 func GuessBestOfFile(filePath string, bytesToDetect int) (*chardet.Result, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, err
+		return nil, opError(OpDetect, filePath, err)
 	}
 	defer CloseQuietly(file)
-	return GuessBestOf(file, bytesToDetect)
+	result, err := GuessBestOf(file, bytesToDetect)
+	return result, opError(OpDetect, filePath, err)
+}
+
+// detectionFailed 将chardet返回的错误标记为ErrDetectionFailed
+func detectionFailed(err error) error {
+	return fmt.Errorf("%w: %w", ErrDetectionFailed, err)
 }
@@ -3,16 +3,32 @@ package charconv
 import (
 	"bytes"
 	"golang.org/x/text/encoding"
-	"golang.org/x/text/transform"
 	"io"
-	"os"
 )
 
+// encoderConverter 创建使用destEncoder的Converter，供Encode系列函数使用
+func encoderConverter(destEncoder *encoding.Encoder, initBuffSize int) *Converter {
+	c, _ := NewConverter(withOp(OpEncode), WithEncoder(destEncoder), withCapacity(initBuffSize))
+	return c
+}
+
+// charsetEncoderConverter 创建编码为destCharset的Converter，字符集不受支持时返回错误
+func charsetEncoderConverter(destCharset string, initBuffSize int) (*Converter, error) {
+	return NewConverter(withOp(OpEncode), WithTargetCharset(destCharset), withCapacity(initBuffSize))
+}
+
+var encodeConverters = newConverterCache(func(charset string) (*Converter, error) {
+	return charsetEncoderConverter(charset, 0)
+})
+
 // Encode 基础编码方法
 func Encode(src io.Reader, dest io.Writer, destEncoder *encoding.Encoder) error {
-	encodeReader := transform.NewReader(src, destEncoder)
-	_, err := io.Copy(dest, encodeReader)
-	return err
+	return encoderConverter(destEncoder, 0).convert(src, dest, nil)
+}
+
+// EncodeWithStats 与Encode相同，同时返回转换过程的统计信息
+func EncodeWithStats(src io.Reader, dest io.Writer, destEncoder *encoding.Encoder) (Stats, error) {
+	return encoderConverter(destEncoder, 0).Convert(src, dest)
 }
 
 func EncodeString(src string, dest io.Writer, destEncoder *encoding.Encoder) error {
@@ -20,88 +36,71 @@ func EncodeString(src string, dest io.Writer, destEncoder *encoding.Encoder) err
 }
 
 func EncodeStringWithCharset(src string, dest io.Writer, destCharset string) error {
-	encoder := EncoderOf(destCharset)
-	if encoder == nil {
-		return unsupported(destCharset)
+	c, err := charsetEncoderConverter(destCharset, 0)
+	if err != nil {
+		return err
 	}
-	return EncodeString(src, dest, encoder)
+	return c.convert(bytes.NewReader([]byte(src)), dest, nil)
 }
 
 func EncodeToBytes(src io.Reader, initBuffSize int, destEncoder *encoding.Encoder) ([]byte, error) {
-	buffer := MakeByteBuffer(initBuffSize)
-	err := Encode(src, buffer, destEncoder)
-	if err != nil {
-		return nil, err
-	}
-	return buffer.Bytes(), err
+	return encoderConverter(destEncoder, initBuffSize).convertToBytes(src, 0, nil)
 }
 
 func EncodeStringToBytes(src string, initBuffSize int, destEncoder *encoding.Encoder) ([]byte, error) {
-	return EncodeToBytes(bytes.NewReader([]byte(src)), initBuffSize, destEncoder)
+	return encoderConverter(destEncoder, initBuffSize).convertBytes(unsafeBytes(src), nil)
 }
 
 func EncodeStringToBytesWithCharset(src string, initBuffSize int, destCharset string) ([]byte, error) {
-	encoder := EncoderOf(destCharset)
-	if encoder == nil {
-		return nil, unsupported(destCharset)
+	c, err := charsetEncoderConverter(destCharset, initBuffSize)
+	if err != nil {
+		return nil, err
 	}
-	return EncodeStringToBytes(src, initBuffSize, encoder)
+	return c.convertBytes(unsafeBytes(src), nil)
 }
 
-func EncodeFile(srcFilePath string, dest io.Writer, destEncoder *encoding.Encoder) error {
-	srcFile, err := os.Open(srcFilePath)
+// AppendEncode 将UTF-8字符串src编码为destCharset并追加到dst末尾，返回追加后的切片。
+// dst剩余容量足以容纳编码结果时不分配内存
+func AppendEncode(dst []byte, src string, destCharset string) ([]byte, error) {
+	c, err := encodeConverters.get(destCharset)
 	if err != nil {
-		return err
+		return dst, err
 	}
-	defer CloseQuietly(srcFile)
-	return Encode(srcFile, dest, destEncoder)
+	return c.AppendString(dst, src)
+}
+
+func EncodeFile(srcFilePath string, dest io.Writer, destEncoder *encoding.Encoder) error {
+	return encoderConverter(destEncoder, 0).convertFile(srcFilePath, dest, nil)
 }
 
 func EncodeFileWithCharset(srcFilePath string, dest io.Writer, destCharset string) error {
-	encoder := EncoderOf(destCharset)
-	if encoder == nil {
-		return unsupported(destCharset)
+	c, err := charsetEncoderConverter(destCharset, 0)
+	if err != nil {
+		return opError(OpEncode, srcFilePath, err)
 	}
-	return EncodeFile(srcFilePath, dest, encoder)
+	return c.convertFile(srcFilePath, dest, nil)
 }
 
 func EncodeFileToBytes(srcFilePath string, initBuffSize int, destEncoder *encoding.Encoder) ([]byte, error) {
-	buffer := MakeByteBuffer(initBuffSize)
-	err := EncodeFile(srcFilePath, buffer, destEncoder)
-	if err != nil {
-		return nil, err
-	}
-	return buffer.Bytes(), nil
+	return encoderConverter(destEncoder, initBuffSize).convertFileToBytes(srcFilePath, nil)
 }
 
 func EncodeFileToBytesWithCharset(srcFilePath string, initBuffSize int, destCharset string) ([]byte, error) {
-	encoder := EncoderOf(destCharset)
-	if encoder == nil {
-		return nil, unsupported(destCharset)
+	c, err := charsetEncoderConverter(destCharset, initBuffSize)
+	if err != nil {
+		return nil, opError(OpEncode, srcFilePath, err)
 	}
-	return EncodeFileToBytes(srcFilePath, initBuffSize, encoder)
+	return c.convertFileToBytes(srcFilePath, nil)
 }
 
 func EncodeFileToFile(srcFilePath string, destFilePath string, destFileFlag int, destEncoder *encoding.Encoder) error {
-	tmpFile, err := os.CreateTemp("", "*")
-	if err != nil {
-		return err
-	}
-	defer RemoveQuietly(tmpFile)
-
-	err = EncodeFile(srcFilePath, tmpFile, destEncoder)
-	if err != nil {
-		return err
-	}
-
-	return CopyTmpFileTo(tmpFile, destFilePath, destFileFlag)
-
+	return encoderConverter(destEncoder, 0).convertFileToFile(srcFilePath, destFilePath, destFileFlag, nil)
 }
 
 func EncodeFileToFileWithCharset(srcFilePath string, destFilePath string, destFileFlag int, destCharset string) error {
-	encoder := EncoderOf(destCharset)
-	if encoder == nil {
-		return unsupported(destCharset)
+	c, err := charsetEncoderConverter(destCharset, 0)
+	if err != nil {
+		return opError(OpEncode, srcFilePath, err)
 	}
-	return EncodeFileToFile(srcFilePath, destFilePath, destFileFlag, encoder)
+	return c.convertFileToFile(srcFilePath, destFilePath, destFileFlag, nil)
 }
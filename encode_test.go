@@ -2,6 +2,7 @@ package charconv
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"os"
 	"testing"
@@ -120,6 +121,37 @@ func TestEncodeStringToBytesWithCharset(t *testing.T) {
 	}
 }
 
+func TestAppendEncode(t *testing.T) {
+	dest, err := AppendEncode([]byte("gbk:"), utf8String, GBK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(dest, append([]byte("gbk:"), gbkData...)) != 0 {
+		t.FailNow()
+	}
+
+	dest = make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		dest, err = AppendEncode(dest[:0], utf8String, GBK)
+	})
+	if err != nil || allocs != 0 && !raceEnabled {
+		t.Fatal(allocs, err)
+	}
+
+	_, err = AppendEncode(nil, utf8String, "no-such-charset")
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkAppendEncode(b *testing.B) {
+	dest := make([]byte, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dest, _ = AppendEncode(dest[:0], utf8String, GBK)
+	}
+}
+
 func TestEncodeFileWithCharset(t *testing.T) {
 	destBuffer := MakeByteBuffer(128)
 	err := EncodeFileWithCharset("./test/test_utf8.txt", destBuffer, GBK)
@@ -176,3 +208,13 @@ func printTestData(t *testing.T) {
 func logDestData(t *testing.T, dest []byte) {
 	t.Log("dest:", dest, "\n")
 }
+
+func TestEncodeFileToFileWriteError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full not available")
+	}
+	err := EncodeFileToFileWithCharset("./test/test_utf8.txt", "/dev/full", os.O_WRONLY, GBK)
+	if err == nil {
+		t.Fatal("write error on destination file should be returned")
+	}
+}
@@ -1,33 +1,261 @@
 package charconv
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+// 可通过errors.Is进行匹配的错误类别
+var (
+	// ErrUnsupported 字符集或转换不受支持
+	ErrUnsupported = errors.New("unsupported charset or conversion")
+	// ErrInvalidSequence 源数据中存在非法字节序列
+	ErrInvalidSequence = errors.New("invalid byte sequence")
+	// ErrUnmappable 目标字符集无法表示源数据中的字符
+	ErrUnmappable = errors.New("unmappable character")
+	// ErrDetectionFailed 无法检测数据的编码
+	ErrDetectionFailed = errors.New("charset detection failed")
+	// ErrTruncated 数据在字符中间或转义状态中结束
+	ErrTruncated = errors.New("truncated character sequence")
+	// ErrLimitExceeded 输入或输出超出设置的大小限制
+	ErrLimitExceeded = errors.New("size limit exceeded")
+	// ErrMixedNewlines 文本中同时存在多种换行符
+	ErrMixedNewlines = errors.New("mixed newlines")
+	// ErrInvalidIndex 索引格式错误或与源文件不匹配
+	ErrInvalidIndex = errors.New("invalid or stale index")
+	// ErrOffsetMapInUse WithOffsetMap设置的OffsetMap正被另一次转换使用
+	ErrOffsetMapInUse = errors.New("offset map in use by another conversion")
+)
+
+// 出错的操作
+const (
+	OpEncode  = "Encode"
+	OpDecode  = "Decode"
+	OpConvert = "Convert"
+	OpDetect  = "Detect"
+)
+
+// OpError 记录出错的操作及相关的文件路径
+type OpError struct {
+	// Op 出错的操作，取值为OpEncode、OpDecode、OpConvert、OpDetect之一
+	Op string
+	// Path 出错的文件路径，与文件无关时为空
+	Path string
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("charconv: %s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("charconv: %s %s: %v", e.Op, e.Path, e.Err)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// opError 使用OpError包装err，err已经是OpError时仅在其缺少路径时补充路径
+func opError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*OpError); ok {
+		if e.Path != "" || path == "" {
+			return e
+		}
+		return &OpError{Op: e.Op, Path: path, Err: e.Err}
+	}
+	if _, ok := err.(repertoireError); ok {
+		// golang.org/x/text编码器返回的无法映射错误
+		err = fmt.Errorf("%w: %w", ErrUnmappable, err)
+	}
+	return &OpError{Op: op, Path: path, Err: err}
+}
 
 type ErrUnsupportedCharset struct {
-	charset string
+	Charset string
 }
 
 type ErrUnsupportedConversion struct {
-	srcCharset  string
-	destCharset string
+	SrcCharset  string
+	DestCharset string
 }
 
 func unsupported(charset string) ErrUnsupportedCharset {
 	return ErrUnsupportedCharset{
-		charset: charset,
+		Charset: charset,
 	}
 }
 
 func unsupportedConversion(srcCharset, destCharset string) ErrUnsupportedConversion {
 	return ErrUnsupportedConversion{
-		srcCharset:  srcCharset,
-		destCharset: destCharset,
+		SrcCharset:  srcCharset,
+		DestCharset: destCharset,
 	}
 }
 
 func (e ErrUnsupportedCharset) Error() string {
-	return fmt.Sprintf("unsupported charset: %s", e.charset)
+	return fmt.Sprintf("unsupported charset: %s", e.Charset)
+}
+
+func (e ErrUnsupportedCharset) Is(target error) bool {
+	return target == ErrUnsupported || target == errors.ErrUnsupported
 }
 
 func (e ErrUnsupportedConversion) Error() string {
-	return fmt.Sprintf("unsupported conversion: %s => %s", e.srcCharset, e.destCharset)
+	return fmt.Sprintf("unsupported conversion: %s => %s", e.SrcCharset, e.DestCharset)
+}
+
+func (e ErrUnsupportedConversion) Is(target error) bool {
+	return target == ErrUnsupported || target == errors.ErrUnsupported
+}
+
+// ErrInvalidByteSequence 源数据中存在非法字节序列
+type ErrInvalidByteSequence struct {
+	// Offset 非法字节序列在源数据中的偏移
+	Offset int64
+	// Bytes 非法字节序列
+	Bytes []byte
+}
+
+// ErrUnmappableRune 目标字符集无法表示源数据中的字符
+type ErrUnmappableRune struct {
+	// Offset 该字符在源数据中的偏移
+	Offset int64
+	// Rune 无法映射的字符
+	Rune rune
+}
+
+func invalidSequence(offset int64, p []byte) ErrInvalidByteSequence {
+	return ErrInvalidByteSequence{
+		Offset: offset,
+		Bytes:  append([]byte(nil), p...),
+	}
+}
+
+func unmappableRune(offset int64, r rune) ErrUnmappableRune {
+	return ErrUnmappableRune{
+		Offset: offset,
+		Rune:   r,
+	}
+}
+
+func (e ErrInvalidByteSequence) Error() string {
+	return fmt.Sprintf("invalid byte sequence % X at offset %d", e.Bytes, e.Offset)
+}
+
+func (e ErrInvalidByteSequence) Is(target error) bool {
+	return target == ErrInvalidSequence
+}
+
+func (e ErrUnmappableRune) Error() string {
+	return fmt.Sprintf("unmappable character %q (%U) at offset %d", e.Rune, e.Rune, e.Offset)
+}
+
+func (e ErrUnmappableRune) Is(target error) bool {
+	return target == ErrUnmappable
+}
+
+// ErrTruncatedStream 数据在字符中间或ISO-2022、HZ的转义状态中结束
+type ErrTruncatedStream struct {
+	// Offset 不完整字符在源数据中的偏移，Escape为true时为最后一次进入非ASCII状态的转义序列的偏移
+	Offset int64
+	// Escape 数据结束时仍处于非ASCII的转义状态
+	Escape bool
+}
+
+func truncatedStream(offset int64, escape bool) ErrTruncatedStream {
+	return ErrTruncatedStream{
+		Offset: offset,
+		Escape: escape,
+	}
+}
+
+func (e ErrTruncatedStream) Error() string {
+	if e.Escape {
+		return fmt.Sprintf("stream ends in escape state entered at offset %d", e.Offset)
+	}
+	return fmt.Sprintf("stream ends inside a character at offset %d", e.Offset)
+}
+
+func (e ErrTruncatedStream) Is(target error) bool {
+	return target == ErrTruncated
+}
+
+// ErrSizeLimit 输入或输出超出设置的大小限制
+type ErrSizeLimit struct {
+	// Kind 超出的限制类型
+	Kind LimitKind
+	// Limit 字节数上限，Kind为LimitExpansion时为按膨胀比例换算得到的输出上限
+	Limit int64
+}
+
+func sizeLimit(kind LimitKind, limit int64) ErrSizeLimit {
+	return ErrSizeLimit{
+		Kind:  kind,
+		Limit: limit,
+	}
+}
+
+func (e ErrSizeLimit) Error() string {
+	return fmt.Sprintf("%s size limit exceeded: %d bytes", e.Kind, e.Limit)
+}
+
+func (e ErrSizeLimit) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// ErrMixedNewlineStyle NewlineDetect策略下，文本中出现了与第一个换行符风格不同的换行符
+type ErrMixedNewlineStyle struct {
+	// Offset 风格不同的换行符在源数据中的偏移
+	Offset int64
+	// First 第一个换行符的风格
+	First LineTerminator
+	// Found 风格不同的换行符
+	Found LineTerminator
+}
+
+func mixedNewlineStyle(offset int64, first, found LineTerminator) ErrMixedNewlineStyle {
+	return ErrMixedNewlineStyle{
+		Offset: offset,
+		First:  first,
+		Found:  found,
+	}
+}
+
+func (e ErrMixedNewlineStyle) Error() string {
+	return fmt.Sprintf("mixed newlines: %s at offset %d, expected %s", e.Found, e.Offset, e.First)
+}
+
+func (e ErrMixedNewlineStyle) Is(target error) bool {
+	return target == ErrMixedNewlines
+}
+
+// ErrStage 流水线中的某个阶段出错，可通过errors.Is、errors.As匹配其包装的错误
+type ErrStage struct {
+	// Index 出错阶段在流水线中的序号，从0开始
+	Index int
+	// Name 出错阶段的名称
+	Name string
+	Err  error
+}
+
+func stageFailed(index int, name string, err error) ErrStage {
+	if _, ok := err.(repertoireError); ok {
+		err = fmt.Errorf("%w: %w", ErrUnmappable, err)
+	}
+	return ErrStage{
+		Index: index,
+		Name:  name,
+		Err:   err,
+	}
+}
+
+func (e ErrStage) Error() string {
+	return fmt.Sprintf("stage %d (%s): %v", e.Index, e.Name, e.Err)
+}
+
+func (e ErrStage) Unwrap() error {
+	return e.Err
 }
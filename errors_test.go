@@ -0,0 +1,60 @@
+package charconv
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestErrUnsupported(t *testing.T) {
+	_, err := EncodeFileToBytesWithCharset("./test/test_utf8.txt", 0, "no-such-charset")
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatal(err)
+	}
+	var opErr *OpError
+	if !errors.As(err, &opErr) || opErr.Op != OpEncode || opErr.Path != "./test/test_utf8.txt" {
+		t.Fatal(err)
+	}
+	var charsetErr ErrUnsupportedCharset
+	if !errors.As(err, &charsetErr) || charsetErr.Charset != "no-such-charset" {
+		t.Fatal(err)
+	}
+
+	err = ConvertBetweenCharsets(bytes.NewReader(gbkData), GBK, MakeByteBuffer(0), GBK)
+	var convErr ErrUnsupportedConversion
+	if !errors.Is(err, ErrUnsupported) || !errors.As(err, &convErr) || convErr.SrcCharset != GBK {
+		t.Fatal(err)
+	}
+}
+
+func TestErrUnmappable(t *testing.T) {
+	err := ConvertBetweenCharsets(bytes.NewReader(utf8Data), UTF8, MakeByteBuffer(0), ISO88591)
+	if !errors.Is(err, ErrUnmappable) {
+		t.Fatal(err)
+	}
+	var opErr *OpError
+	if !errors.As(err, &opErr) || opErr.Op != OpConvert {
+		t.Fatal(err)
+	}
+}
+
+func TestErrInvalidSequence(t *testing.T) {
+	decoder := WrapDecoder(DecoderOf(GBK), PolicyStrict, nil)
+	_, err := DecodeBytesToBytes([]byte{'a', 0xFF}, 0, decoder)
+	var seqErr ErrInvalidByteSequence
+	if !errors.Is(err, ErrInvalidSequence) || !errors.As(err, &seqErr) || seqErr.Offset != 1 {
+		t.Fatal(err)
+	}
+}
+
+func TestErrDetect(t *testing.T) {
+	_, err := GuessBestOfFile("./test/not_exists.txt", 1024)
+	var opErr *OpError
+	if !errors.As(err, &opErr) || opErr.Op != OpDetect || opErr.Path != "./test/not_exists.txt" {
+		t.Fatal(err)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+}
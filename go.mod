@@ -1,6 +1,6 @@
 module github.com/zimolab/charconv
 
-go 1.19
+go 1.21
 
 require (
 	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d
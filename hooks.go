@@ -0,0 +1,507 @@
+package charconv
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Policy 遇到非法字节序列或无法映射字符时的处理策略
+type Policy int
+
+const (
+	// PolicyDefault 与golang.org/x/text的默认行为一致：解码时替换为U+FFFD，编码时返回错误
+	PolicyDefault Policy = iota
+	// PolicyReplace 解码时替换为U+FFFD，编码时替换为目标字符集的替代字符
+	PolicyReplace
+	// PolicySkip 丢弃非法字节序列或无法映射的字符
+	PolicySkip
+	// PolicyTransliterate 编码时尝试将无法映射的字符音译为近似字符，失败时替换为替代字符。
+	// 解码时与PolicyReplace相同
+	PolicyTransliterate
+	// PolicyStrict 遇到非法字节序列或无法映射的字符时返回错误
+	PolicyStrict
+)
+
+// Action 对非法字节序列或无法映射字符实际采取的动作
+type Action int
+
+const (
+	ActionReplaced Action = iota
+	ActionSkipped
+	ActionTransliterated
+	ActionError
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionReplaced:
+		return "replaced"
+	case ActionSkipped:
+		return "skipped"
+	case ActionTransliterated:
+		return "transliterated"
+	case ActionError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// EventKind 事件类型
+type EventKind int
+
+const (
+	// EventInvalidSequence 输入中存在非法字节序列
+	EventInvalidSequence EventKind = iota
+	// EventUnmappable 输入中存在目标字符集无法表示的字符
+	EventUnmappable
+)
+
+// Event 转换过程中遇到非法字节序列或无法映射字符时产生的事件
+type Event struct {
+	Kind EventKind
+	// Offset 问题字节在源数据中的偏移
+	Offset int64
+	// Bytes 非法字节序列，仅在回调期间有效，如需保留请自行拷贝
+	Bytes []byte
+	// Rune 无法映射的字符，Kind为EventInvalidSequence时为utf8.RuneError
+	Rune rune
+	// Action 实际采取的处理动作
+	Action Action
+}
+
+// Handler 事件回调接口
+type Handler interface {
+	HandleEvent(e Event)
+}
+
+// HandlerFunc 将普通函数适配为Handler
+type HandlerFunc func(e Event)
+
+func (f HandlerFunc) HandleEvent(e Event) {
+	f(e)
+}
+
+// WrapDecoder 包装decoder，按policy处理非法字节序列，并在每次遇到非法字节序列时调用handler（可为nil）。
+// 包装后的Decoder逐字符调用底层解码器以定位非法字节序列，速度慢于原始Decoder
+func WrapDecoder(decoder *encoding.Decoder, policy Policy, handler Handler) *encoding.Decoder {
+	return &encoding.Decoder{Transformer: newDecodeHandler(decoder, policy, handler)}
+}
+
+// WrapEncoder 包装encoder，按policy处理无法映射的字符及非法UTF-8序列，并在每次遇到时调用handler（可为nil）
+func WrapEncoder(encoder *encoding.Encoder, policy Policy, handler Handler) *encoding.Encoder {
+	return &encoding.Encoder{Transformer: newEncodeHandler(encoder, policy, handler)}
+}
+
+// repertoireError golang.org/x/text编码器在遇到无法映射字符时返回的错误
+type repertoireError interface {
+	Replacement() byte
+}
+
+// legitFFFD 各字符集中U+FFFD本身的合法编码，解码得到这些序列时不视为非法字节序列
+var legitFFFD = [][]byte{
+	[]byte("\uFFFD"),
+	// UTF-16BE、UTF-16LE
+	{0xFF, 0xFD},
+	{0xFD, 0xFF},
+	// UTF-32BE、UTF-32LE
+	{0x00, 0x00, 0xFF, 0xFD},
+	{0xFD, 0xFF, 0x00, 0x00},
+	// GB18030
+	{0x84, 0x31, 0xA4, 0x37},
+}
+
+func isLegitFFFD(p []byte) bool {
+	for _, l := range legitFFFD {
+		if bytes.Equal(p, l) {
+			return true
+		}
+	}
+	return false
+}
+
+var fffd = []byte("\uFFFD")
+
+// decodeHandler 逐字符驱动底层解码器，以便准确定位每个非法字节序列
+type decodeHandler struct {
+	inner   transform.Transformer
+	policy  Policy
+	handler Handler
+	stats   *Stats
+	// track 不为nil时记录解码输出偏移到源偏移的映射
+	track *offsetTrack
+	// mapping 不为nil时逐字符记录源数据与解码结果的偏移映射
+	mapping *OffsetMap
+	// mappingErr 偏移映射正被其他转换使用，不为nil时Transform直接返回该错误
+	mappingErr error
+	srcOff     int64
+	dstOff     int64
+}
+
+func newDecodeHandler(inner transform.Transformer, policy Policy, handler Handler) *decodeHandler {
+	return &decodeHandler{inner: inner, policy: policy, handler: handler}
+}
+
+func (h *decodeHandler) Reset() {
+	h.inner.Reset()
+	h.srcOff = 0
+	h.dstOff = 0
+	if h.track != nil {
+		h.track.reset()
+	}
+	if h.mapping != nil {
+		h.mapping.reset()
+	}
+}
+
+func (h *decodeHandler) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	if h.mappingErr != nil {
+		return 0, 0, h.mappingErr
+	}
+	nDst, nSrc, err = h.transform(dst, src, atEOF)
+	h.srcOff += int64(nSrc)
+	h.dstOff += int64(nDst)
+	return nDst, nSrc, err
+}
+
+func (h *decodeHandler) transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		// 逐步放大输出窗口，保证每次调用底层解码器最多产生一个字符
+		var dn, sn int
+		for w := 1; ; w++ {
+			if nDst+w > len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			dn, sn, err = h.inner.Transform(dst[nDst:nDst+w], src[nSrc:], atEOF)
+			if err != transform.ErrShortDst || dn > 0 || sn > 0 || w == utf8.UTFMax {
+				break
+			}
+		}
+		if dn == 0 && sn == 0 {
+			return nDst, nSrc, err
+		}
+
+		if dn == len(fffd) && bytes.Equal(dst[nDst:nDst+dn], fffd) && !isLegitFFFD(src[nSrc:nSrc+sn]) {
+			action := h.invalidAction()
+			if h.stats != nil {
+				h.stats.InvalidSequences++
+			}
+			if h.handler != nil {
+				h.handler.HandleEvent(Event{
+					Kind:   EventInvalidSequence,
+					Offset: h.srcOff + int64(nSrc),
+					Bytes:  src[nSrc : nSrc+sn],
+					Rune:   utf8.RuneError,
+					Action: action,
+				})
+			}
+			switch action {
+			case ActionError:
+				return nDst, nSrc, invalidSequence(h.srcOff+int64(nSrc), src[nSrc:nSrc+sn])
+			case ActionSkipped:
+				dn = 0
+			}
+		}
+
+		if h.track != nil && dn > 0 {
+			h.track.add(h.dstOff+int64(nDst), h.srcOff+int64(nSrc), dn, sn)
+		}
+		if h.mapping != nil {
+			h.mapping.add(sn, dst[nDst:nDst+dn])
+		}
+		nDst += dn
+		nSrc += sn
+		if err != nil && err != transform.ErrShortDst {
+			return nDst, nSrc, err
+		}
+	}
+	return nDst, nSrc, nil
+}
+
+func (h *decodeHandler) invalidAction() Action {
+	switch h.policy {
+	case PolicySkip:
+		return ActionSkipped
+	case PolicyStrict:
+		return ActionError
+	}
+	return ActionReplaced
+}
+
+// encodeHandler 处理底层编码器遇到的无法映射字符，逻辑与golang.org/x/text/encoding中的errorHandler类似
+type encodeHandler struct {
+	inner   transform.Transformer
+	policy  Policy
+	handler Handler
+	stats   *Stats
+	// origin 不为nil时，用于将编码器输入（UTF-8）中的偏移换算为原始源数据中的偏移
+	origin *offsetTrack
+	srcOff int64
+}
+
+func newEncodeHandler(inner transform.Transformer, policy Policy, handler Handler) *encodeHandler {
+	return &encodeHandler{inner: inner, policy: policy, handler: handler}
+}
+
+func (h *encodeHandler) Reset() {
+	h.inner.Reset()
+	h.srcOff = 0
+}
+
+func (h *encodeHandler) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	nDst, nSrc, err = h.transform(dst, src, atEOF)
+	h.srcOff += int64(nSrc)
+	if h.origin != nil {
+		h.origin.discard(h.srcOff)
+	}
+	return nDst, nSrc, err
+}
+
+func (h *encodeHandler) transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		end := nSrc + invalidUTF8Index(src[nSrc:])
+		if end > nSrc {
+			dn, sn, e := h.inner.Transform(dst[nDst:], src[nSrc:end], atEOF && end == len(src))
+			nDst += dn
+			nSrc += sn
+			if e != nil {
+				rerr, ok := e.(repertoireError)
+				if !ok {
+					return nDst, nSrc, e
+				}
+				r, size := utf8.DecodeRune(src[nSrc:])
+				dn, err = h.unmappable(dst[nDst:], r, rerr, nSrc)
+				if err != nil {
+					return nDst, nSrc, err
+				}
+				nDst += dn
+				nSrc += size
+				continue
+			}
+		}
+		if nSrc == len(src) {
+			break
+		}
+		if !atEOF && !utf8.FullRune(src[nSrc:]) {
+			return nDst, nSrc, transform.ErrShortSrc
+		}
+		dn, err := h.invalid(dst[nDst:], src[nSrc:nSrc+1], nSrc)
+		if err != nil {
+			return nDst, nSrc, err
+		}
+		nDst += dn
+		nSrc++
+	}
+	if atEOF {
+		// 有状态的编码器（如ISO-2022-JP）需要在结尾输出恢复初始状态的转义序列
+		dn, _, e := h.inner.Transform(dst[nDst:], nil, true)
+		nDst += dn
+		if e != nil {
+			return nDst, nSrc, e
+		}
+	}
+	return nDst, nSrc, nil
+}
+
+// invalidUTF8Index 返回p中第一个非法（或不完整）UTF-8序列的位置，不存在时返回len(p)
+func invalidUTF8Index(p []byte) int {
+	if utf8.Valid(p) {
+		return len(p)
+	}
+	for i := 0; i < len(p); {
+		if p[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(p[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i
+		}
+		i += size
+	}
+	return len(p)
+}
+
+// minHandlerDst 处理单个字符时要求的最小输出空间，保证回退结果（替代字符、音译结果）能够完整写入
+const minHandlerDst = 32
+
+// offset 返回本次调用中位于pos处的字节在源数据中的偏移
+func (h *encodeHandler) offset(pos int) int64 {
+	off := h.srcOff + int64(pos)
+	if h.origin != nil {
+		return h.origin.sourceOffset(off)
+	}
+	return off
+}
+
+// invalid 处理编码器输入中的非法UTF-8字节
+func (h *encodeHandler) invalid(dst []byte, p []byte, pos int) (int, error) {
+	if len(dst) < minHandlerDst {
+		return 0, transform.ErrShortDst
+	}
+	action := ActionReplaced
+	switch h.policy {
+	case PolicySkip:
+		action = ActionSkipped
+	case PolicyStrict:
+		action = ActionError
+	}
+	offset := h.offset(pos)
+	if h.handler != nil {
+		h.handler.HandleEvent(Event{
+			Kind:   EventInvalidSequence,
+			Offset: offset,
+			Bytes:  p,
+			Rune:   utf8.RuneError,
+			Action: action,
+		})
+	}
+	switch action {
+	case ActionError:
+		return 0, invalidSequence(offset, p)
+	case ActionSkipped:
+		return 0, nil
+	}
+	n, _, err := h.inner.Transform(dst, fffd, false)
+	if rerr, ok := err.(repertoireError); ok {
+		dst[n] = rerr.Replacement()
+		return n + 1, nil
+	}
+	return n, err
+}
+
+// unmappable 处理编码器无法映射的字符
+func (h *encodeHandler) unmappable(dst []byte, r rune, rerr repertoireError, pos int) (int, error) {
+	if len(dst) < minHandlerDst {
+		return 0, transform.ErrShortDst
+	}
+	n := 0
+	action := ActionReplaced
+	switch h.policy {
+	case PolicyDefault, PolicyStrict:
+		action = ActionError
+	case PolicySkip:
+		action = ActionSkipped
+	case PolicyTransliterate:
+		if s := transliterate(r); s != "" {
+			var err error
+			n, _, err = h.inner.Transform(dst, []byte(s), false)
+			if err == nil {
+				action = ActionTransliterated
+			} else {
+				n = 0
+			}
+		}
+	}
+	if action == ActionReplaced {
+		dst[n] = rerr.Replacement()
+		n++
+	}
+
+	offset := h.offset(pos)
+	if h.handler != nil {
+		h.handler.HandleEvent(Event{
+			Kind:   EventUnmappable,
+			Offset: offset,
+			Rune:   r,
+			Action: action,
+		})
+	}
+	if action == ActionError {
+		return 0, unmappableRune(offset, r)
+	}
+	if h.stats != nil {
+		h.stats.Unmappable++
+	}
+	return n, nil
+}
+
+// transliterations 无法通过兼容分解得到近似字符的常见符号
+var transliterations = map[rune]string{
+	'‘': "'", '’': "'", '‚': "'", '‛': "'",
+	'“': "\"", '”': "\"", '„': "\"", '‟': "\"",
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '―': "-",
+	'…': "...", '•': "*", '€': "EUR", '£': "GBP", '©': "(C)", '®': "(R)", '™': "TM",
+	'«': "<<", '»': ">>", '×': "x", '÷': "/", 'ß': "ss", 'Æ': "AE", 'æ': "ae",
+	'Ø': "O", 'ø': "o", 'Œ': "OE", 'œ': "oe", 'Đ': "D", 'đ': "d", 'Ł': "L", 'ł': "l",
+	'\u00A0': " ", '\u200B': "",
+}
+
+// transliterate 返回r的近似表示：先查表，再通过NFKD分解去除组合附加符号，无法音译时返回空字符串
+func transliterate(r rune) string {
+	if s, ok := transliterations[r]; ok {
+		return s
+	}
+	var buf [utf8.UTFMax]byte
+	decomposed := norm.NFKD.Bytes(buf[:utf8.EncodeRune(buf[:], r)])
+	result := make([]byte, 0, len(decomposed))
+	for i := 0; i < len(decomposed); {
+		c, size := utf8.DecodeRune(decomposed[i:])
+		if !unicode.Is(unicode.Mn, c) {
+			result = append(result, decomposed[i:i+size]...)
+		}
+		i += size
+	}
+	if len(result) == 0 || string(result) == string(r) {
+		return ""
+	}
+	return string(result)
+}
+
+// offsetRun 一段步长一致的偏移映射：第k步的输出偏移为out+k*outStep，对应源偏移为src+k*srcStep
+type offsetRun struct {
+	out, src         int64
+	outStep, srcStep int
+	n                int
+}
+
+// offsetTrack 以游程形式记录解码输出偏移与源偏移的对应关系
+type offsetTrack struct {
+	runs []offsetRun
+}
+
+func (t *offsetTrack) reset() {
+	t.runs = t.runs[:0]
+}
+
+func (t *offsetTrack) add(out, src int64, outLen, srcLen int) {
+	if k := len(t.runs); k > 0 {
+		last := &t.runs[k-1]
+		if last.outStep == outLen && last.srcStep == srcLen &&
+			last.out+int64(last.n*last.outStep) == out && last.src+int64(last.n*last.srcStep) == src {
+			last.n++
+			return
+		}
+	}
+	t.runs = append(t.runs, offsetRun{out: out, src: src, outStep: outLen, srcStep: srcLen, n: 1})
+}
+
+// sourceOffset 将解码输出中的偏移换算为源偏移
+func (t *offsetTrack) sourceOffset(out int64) int64 {
+	for i := len(t.runs) - 1; i >= 0; i-- {
+		run := t.runs[i]
+		if out >= run.out {
+			k := (out - run.out) / int64(run.outStep)
+			return run.src + k*int64(run.srcStep)
+		}
+	}
+	return out
+}
+
+// discard 丢弃完全位于out之前的记录
+func (t *offsetTrack) discard(out int64) {
+	i := 0
+	for ; i < len(t.runs)-1; i++ {
+		run := t.runs[i+1]
+		if run.out > out {
+			break
+		}
+	}
+	if i > 0 {
+		t.runs = append(t.runs[:0], t.runs[i:]...)
+	}
+}
@@ -0,0 +1,136 @@
+package charconv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func collectEvents(events *[]Event) Handler {
+	return HandlerFunc(func(e Event) {
+		e.Bytes = append([]byte(nil), e.Bytes...)
+		*events = append(*events, e)
+	})
+}
+
+func TestWrapDecoder(t *testing.T) {
+	src := append([]byte("ab"), 0xFF)
+	src = append(src, gbkData...)
+	src = append(src, 0x81)
+
+	var events []Event
+	decoder := WrapDecoder(DecoderOf(GBK), PolicyReplace, collectEvents(&events))
+	dest, err := DecodeBytesToBytes(src, 0, decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dest) != "ab\uFFFD"+utf8String+"\uFFFD" {
+		t.Fatal(string(dest))
+	}
+	if len(events) != 2 {
+		t.Fatal(events)
+	}
+	if events[0].Offset != 2 || !bytes.Equal(events[0].Bytes, []byte{0xFF}) || events[0].Action != ActionReplaced {
+		t.Fatal(events[0])
+	}
+	if events[1].Offset != int64(len(src)-1) || events[1].Kind != EventInvalidSequence {
+		t.Fatal(events[1])
+	}
+}
+
+func TestWrapDecoderSkipAndStrict(t *testing.T) {
+	src := append([]byte{0xFF}, gbkData...)
+	dest, err := DecodeBytesToBytes(src, 0, WrapDecoder(DecoderOf(GBK), PolicySkip, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dest, utf8Data) {
+		t.Fatal(string(dest))
+	}
+
+	_, err = DecodeBytesToBytes(src, 0, WrapDecoder(DecoderOf(GBK), PolicyStrict, nil))
+	var target ErrInvalidByteSequence
+	if !errors.As(err, &target) {
+		t.Fatal(err)
+	}
+}
+
+func TestWrapDecoderLegitFFFD(t *testing.T) {
+	var events []Event
+	decoder := WrapDecoder(DecoderOf(UTF8), PolicyStrict, collectEvents(&events))
+	dest, err := DecodeBytesToBytes([]byte("a\uFFFDb"), 0, decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dest) != "a\uFFFDb" || len(events) != 0 {
+		t.Fatal(string(dest), events)
+	}
+
+	for _, charset := range []string{UTF16BE, UTF16LE, UTF32BE, UTF32LE, GB18030} {
+		src, err := EncodeStringToBytesWithCharset("a\uFFFDb", 0, charset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := WrapDecoder(DecoderOf(charset), PolicyStrict, collectEvents(&events))
+		dest, err := DecodeBytesToBytes(src, 0, decoder)
+		if err != nil || string(dest) != "a\uFFFDb" || len(events) != 0 {
+			t.Fatal(charset, string(dest), events, err)
+		}
+	}
+}
+
+func TestWrapEncoder(t *testing.T) {
+	src := "a€b\xFFcő"
+	var events []Event
+	encoder := WrapEncoder(EncoderOf(ISO88591), PolicyTransliterate, collectEvents(&events))
+	dest, err := EncodeStringToBytes(src, 0, encoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dest) != "aEURb\x1aco" {
+		t.Fatalf("%q", dest)
+	}
+	if len(events) != 3 {
+		t.Fatal(events)
+	}
+	if events[0].Kind != EventUnmappable || events[0].Rune != '€' || events[0].Offset != 1 || events[0].Action != ActionTransliterated {
+		t.Fatal(events[0])
+	}
+	if events[1].Kind != EventInvalidSequence || events[1].Offset != 5 || events[1].Action != ActionReplaced {
+		t.Fatal(events[1])
+	}
+	if events[2].Rune != 'ő' || events[2].Offset != 7 {
+		t.Fatal(events[2])
+	}
+}
+
+func TestWrapEncoderStrict(t *testing.T) {
+	var events []Event
+	encoder := WrapEncoder(EncoderOf(ISO88591), PolicyStrict, collectEvents(&events))
+	_, err := EncodeStringToBytes("abc你", 0, encoder)
+	var target ErrUnmappableRune
+	if !errors.As(err, &target) {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Offset != 3 || events[0].Action != ActionError {
+		t.Fatal(events)
+	}
+}
+
+func TestConvertEventOffsets(t *testing.T) {
+	var events []Event
+	encoder := WrapEncoder(EncoderOf(ISO88591), PolicyReplace, collectEvents(&events))
+	src := append([]byte("ab"), gbkData...)
+	stats, err := ConvertWithStats(bytes.NewReader(src), MakeByteBuffer(0), DecoderOf(GBK), encoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 5 || stats.Unmappable != 5 {
+		t.Fatal(events, stats)
+	}
+	for i, e := range events {
+		if e.Offset != int64(2+2*i) {
+			t.Fatal(i, e)
+		}
+	}
+}
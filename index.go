@@ -0,0 +1,426 @@
+package charconv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultIndexInterval 建立索引时相邻检查点之间源数据的默认间隔
+const DefaultIndexInterval = 1 << 20
+
+// IndexSuffix OpenIndexed使用的索引文件（sidecar）的后缀
+const IndexSuffix = ".ccidx"
+
+// indexMagic 索引文件的文件头
+const indexMagic = "charconv-index 1\n"
+
+// maxIndexCharsetLen 索引中字符集名称的最大长度，超过时视为索引损坏
+const maxIndexCharsetLen = 64
+
+// Checkpoint 索引中的检查点，从SrcOffset处以新的解码器开始解码，得到的数据与完整解码结果中DecodedOffset之后的数据相同
+type Checkpoint struct {
+	// SrcOffset 检查点在源文件中的偏移
+	SrcOffset int64
+	// DecodedOffset 检查点在解码后的UTF-8数据中的偏移
+	DecodedOffset int64
+	// Line 检查点之前的\n的个数，即检查点所在行的行号（从0开始）
+	Line int64
+}
+
+// Index 源文件解码结果的稀疏检查点索引
+type Index struct {
+	// Charset 源文件的字符集
+	Charset string
+	// SrcSize 建立索引时源文件的大小
+	SrcSize int64
+	// SrcModTime 建立索引时源文件的修改时间
+	SrcModTime time.Time
+	// DecodedSize 解码后的UTF-8数据的大小
+	DecodedSize int64
+	// Lines 解码后的数据中\n的个数
+	Lines int64
+	// Checkpoints 按偏移排序的检查点，第一个检查点总是位于数据开头
+	Checkpoints []Checkpoint
+}
+
+// BuildIndex 解码charset编码的文件srcPath，每隔约interval字节的源数据在安全的字符边界处记录一个检查点。
+// interval不大于0时使用DefaultIndexInterval。
+// ISO-2022-JP、HZ-GB-2312只在ASCII状态下记录检查点；需要BOM确定字节序的UTF-16只记录开头的检查点
+func BuildIndex(srcPath string, charset string, interval int64) (*Index, error) {
+	if interval <= 0 {
+		interval = DefaultIndexInterval
+	}
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return nil, opError(OpDecode, srcPath, err)
+	}
+	defer CloseQuietly(f)
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, opError(OpDecode, srcPath, err)
+	}
+
+	r, err := NewReader(f, charset, withOp(OpDecode))
+	if err != nil {
+		return nil, opError(OpDecode, srcPath, err)
+	}
+	defer r.Close()
+	resumable := r.shift != nil || splitterOf(r.conv.srcCharset) != nil
+
+	idx := &Index{
+		Charset:     charset,
+		SrcSize:     fi.Size(),
+		SrcModTime:  fi.ModTime(),
+		Checkpoints: []Checkpoint{{}},
+	}
+	last := int64(0)
+	for r.err == nil {
+		r.step()
+		out := r.dst[r.dst0:r.dst1]
+		idx.DecodedSize += int64(len(out))
+		idx.Lines += int64(bytes.Count(out, []byte{'\n'}))
+		r.dst0 = r.dst1
+
+		// 每次转换后已消耗的源数据都停在字符边界上
+		if resumable && r.consumed-last >= interval && !r.flush && (r.shift == nil || r.shift.resumable()) {
+			last = r.consumed
+			idx.Checkpoints = append(idx.Checkpoints, Checkpoint{
+				SrcOffset:     r.consumed,
+				DecodedOffset: idx.DecodedSize,
+				Line:          idx.Lines,
+			})
+		}
+	}
+	if r.err != io.EOF {
+		return nil, opError(OpDecode, srcPath, r.err)
+	}
+	return idx, nil
+}
+
+// resumable 判断当前位置是否可以用新的解码器继续解码
+func (s *shiftTracker) resumable() bool {
+	return !s.shifted && s.esc == 0
+}
+
+// checkpointBefore 返回DecodedOffset不大于offset的最后一个检查点
+func (idx *Index) checkpointBefore(offset int64) Checkpoint {
+	i := sort.Search(len(idx.Checkpoints), func(i int) bool {
+		return idx.Checkpoints[i].DecodedOffset > offset
+	})
+	return idx.Checkpoints[i-1]
+}
+
+// checkpointBeforeLine 返回Line不大于line的最后一个检查点
+func (idx *Index) checkpointBeforeLine(line int64) Checkpoint {
+	i := sort.Search(len(idx.Checkpoints), func(i int) bool {
+		return idx.Checkpoints[i].Line > line
+	})
+	return idx.Checkpoints[i-1]
+}
+
+// matches 判断索引是否与源文件fi及字符集charset匹配
+func (idx *Index) matches(fi os.FileInfo, charset string) bool {
+	return charsetEquals(idx.Charset, charset) && idx.SrcSize == fi.Size() && idx.SrcModTime.Equal(fi.ModTime())
+}
+
+// WriteTo 将索引以二进制格式写入w
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	buf := []byte(indexMagic)
+	buf = binary.AppendUvarint(buf, uint64(len(idx.Charset)))
+	buf = append(buf, idx.Charset...)
+	buf = binary.AppendVarint(buf, idx.SrcSize)
+	buf = binary.AppendVarint(buf, idx.SrcModTime.UnixNano())
+	buf = binary.AppendVarint(buf, idx.DecodedSize)
+	buf = binary.AppendVarint(buf, idx.Lines)
+	buf = binary.AppendUvarint(buf, uint64(len(idx.Checkpoints)))
+	var prev Checkpoint
+	for _, cp := range idx.Checkpoints {
+		buf = binary.AppendVarint(buf, cp.SrcOffset-prev.SrcOffset)
+		buf = binary.AppendVarint(buf, cp.DecodedOffset-prev.DecodedOffset)
+		buf = binary.AppendVarint(buf, cp.Line-prev.Line)
+		prev = cp
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// ReadIndex 读取WriteTo写入的索引
+func ReadIndex(r io.Reader) (*Index, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != indexMagic {
+		return nil, fmt.Errorf("%w: bad header", ErrInvalidIndex)
+	}
+
+	var err error
+	varint := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var v int64
+		v, err = binary.ReadVarint(br)
+		return v
+	}
+	uvarint := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = binary.ReadUvarint(br)
+		return v
+	}
+
+	idx := &Index{}
+	// 长度与数量均来自索引文件，不能直接用于分配内存
+	size := uvarint()
+	if err == nil && size > maxIndexCharsetLen {
+		err = errors.New("bad charset length")
+	}
+	if err != nil {
+		size = 0
+	}
+	charset := make([]byte, size)
+	if err == nil {
+		_, err = io.ReadFull(br, charset)
+	}
+	idx.Charset = string(charset)
+	idx.SrcSize = varint()
+	idx.SrcModTime = time.Unix(0, varint())
+	idx.DecodedSize = varint()
+	idx.Lines = varint()
+	if err == nil && (idx.SrcSize < 0 || idx.DecodedSize < 0 || idx.Lines < 0) {
+		err = errors.New("bad size")
+	}
+	n := uvarint()
+	if err == nil && (n == 0 || n > uint64(idx.SrcSize)+1) {
+		err = errors.New("bad checkpoint count")
+	}
+	// 检查点逐个追加而不预先分配，占用的内存不超过实际读取的数据量的常数倍
+	var prev Checkpoint
+	for i := uint64(0); i < n && err == nil; i++ {
+		cp := Checkpoint{
+			SrcOffset:     prev.SrcOffset + varint(),
+			DecodedOffset: prev.DecodedOffset + varint(),
+			Line:          prev.Line + varint(),
+		}
+		idx.Checkpoints = append(idx.Checkpoints, cp)
+		prev = cp
+	}
+	if err == nil {
+		err = idx.validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIndex, err)
+	}
+	return idx, nil
+}
+
+// validate 检查索引的检查点：第一个检查点位于数据开头，各偏移与行号不递减且不超过数据的大小与行数
+func (idx *Index) validate() error {
+	if len(idx.Checkpoints) == 0 || idx.Checkpoints[0] != (Checkpoint{}) {
+		return errors.New("bad first checkpoint")
+	}
+	prev := idx.Checkpoints[0]
+	for _, cp := range idx.Checkpoints[1:] {
+		if cp.SrcOffset < prev.SrcOffset || cp.DecodedOffset < prev.DecodedOffset || cp.Line < prev.Line ||
+			cp.SrcOffset > idx.SrcSize || cp.DecodedOffset > idx.DecodedSize || cp.Line > idx.Lines {
+			return errors.New("bad checkpoint")
+		}
+		prev = cp
+	}
+	return nil
+}
+
+// Save 将索引写入文件path
+func (idx *Index) Save(path string) error {
+	return writeAtomic(OpDecode, path, CreateOrTrunc, func(w io.Writer) error {
+		_, err := idx.WriteTo(w)
+		return err
+	})
+}
+
+// LoadIndex 读取Save写入的索引文件
+func LoadIndex(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseQuietly(f)
+	return ReadIndex(f)
+}
+
+// IndexedReader 以UTF-8的形式随机读取charset编码的文件，借助检查点索引定位，无需从头解码
+type IndexedReader struct {
+	f   *os.File
+	idx *Index
+	r   *Reader
+	// pos 下一次Read的解码后偏移
+	pos int64
+	// rpos r当前所处的解码后偏移，active为false时r尚未定位
+	rpos   int64
+	active bool
+}
+
+// OpenIndexed 打开charset编码的文件srcPath。与其同名、后缀为IndexSuffix的索引文件与源文件匹配时直接使用，
+// 否则重新建立索引并保存到该文件，保存失败不影响读取
+func OpenIndexed(srcPath string, charset string) (*IndexedReader, error) {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return nil, opError(OpDecode, srcPath, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		CloseQuietly(f)
+		return nil, opError(OpDecode, srcPath, err)
+	}
+
+	sidecar := srcPath + IndexSuffix
+	idx, err := LoadIndex(sidecar)
+	if err != nil || !idx.matches(fi, charset) {
+		idx, err = BuildIndex(srcPath, charset, 0)
+		if err != nil {
+			CloseQuietly(f)
+			return nil, err
+		}
+		if err := idx.Save(sidecar); err != nil {
+			logError("error on saving index", "file", sidecar, "error", err)
+		}
+	}
+	return newIndexedReader(f, idx)
+}
+
+// NewIndexedReader 使用索引idx随机读取文件f，f的内容必须与建立索引时相同。IndexedReader关闭时会关闭f
+func NewIndexedReader(f *os.File, idx *Index) (*IndexedReader, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, opError(OpDecode, f.Name(), err)
+	}
+	if fi.Size() != idx.SrcSize || idx.validate() != nil {
+		return nil, opError(OpDecode, f.Name(), ErrInvalidIndex)
+	}
+	return newIndexedReader(f, idx)
+}
+
+func newIndexedReader(f *os.File, idx *Index) (*IndexedReader, error) {
+	r, err := NewReader(nil, idx.Charset, withOp(OpDecode))
+	if err != nil {
+		CloseQuietly(f)
+		return nil, opError(OpDecode, f.Name(), err)
+	}
+	return &IndexedReader{f: f, idx: idx, r: r}, nil
+}
+
+// Index 返回使用的索引
+func (ir *IndexedReader) Index() *Index {
+	return ir.idx
+}
+
+// Size 返回解码后的UTF-8数据的大小
+func (ir *IndexedReader) Size() int64 {
+	return ir.idx.DecodedSize
+}
+
+func (ir *IndexedReader) Read(p []byte) (int, error) {
+	if !ir.active || ir.rpos != ir.pos {
+		if err := ir.position(ir.pos); err != nil {
+			return 0, err
+		}
+	}
+	n, err := ir.r.Read(p)
+	ir.rpos += int64(n)
+	ir.pos = ir.rpos
+	return n, err
+}
+
+// Seek 实现io.Seeker，offset为解码后的UTF-8数据中的偏移。定位到多字节字符中间时，从该位置开始读取字符的剩余字节
+func (ir *IndexedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += ir.pos
+	case io.SeekEnd:
+		offset += ir.idx.DecodedSize
+	default:
+		return 0, errors.New("charconv: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("charconv: negative position")
+	}
+	ir.pos = offset
+	return offset, nil
+}
+
+// SeekLine 定位到第line行（从0开始）的开头，返回该位置在解码后的UTF-8数据中的偏移
+func (ir *IndexedReader) SeekLine(line int64) (int64, error) {
+	if line < 0 || line > ir.idx.Lines {
+		return 0, fmt.Errorf("charconv: line %d out of range [0, %d]", line, ir.idx.Lines)
+	}
+	cp := ir.idx.checkpointBeforeLine(line)
+	if err := ir.reset(cp); err != nil {
+		return 0, err
+	}
+
+	offset := cp.DecodedOffset
+	remaining := line - cp.Line
+	buf := make([]byte, defaultBufferSize)
+	for remaining > 0 {
+		n, err := ir.r.Read(buf)
+		ir.rpos += int64(n)
+		p := buf[:n]
+		for remaining > 0 {
+			i := bytes.IndexByte(p, '\n')
+			if i < 0 {
+				offset += int64(len(p))
+				break
+			}
+			offset += int64(i + 1)
+			p = p[i+1:]
+			remaining--
+		}
+		if remaining > 0 && err != nil {
+			return 0, opError(OpDecode, ir.f.Name(), err)
+		}
+	}
+	ir.pos = offset
+	return offset, nil
+}
+
+// position 使r定位到解码后的偏移offset
+func (ir *IndexedReader) position(offset int64) error {
+	cp := ir.idx.checkpointBefore(offset)
+	if !ir.active || ir.rpos > offset || ir.rpos < cp.DecodedOffset {
+		if err := ir.reset(cp); err != nil {
+			return err
+		}
+	}
+	n, err := io.CopyN(io.Discard, ir.r, offset-ir.rpos)
+	ir.rpos += n
+	if err != nil && err != io.EOF {
+		return opError(OpDecode, ir.f.Name(), err)
+	}
+	return nil
+}
+
+// reset 使r从检查点cp开始解码
+func (ir *IndexedReader) reset(cp Checkpoint) error {
+	if cp.SrcOffset < 0 || cp.SrcOffset > ir.idx.SrcSize {
+		return opError(OpDecode, ir.f.Name(), ErrInvalidIndex)
+	}
+	ir.r.Reset(io.NewSectionReader(ir.f, cp.SrcOffset, ir.idx.SrcSize-cp.SrcOffset))
+	ir.rpos = cp.DecodedOffset
+	ir.active = true
+	return nil
+}
+
+// Close 关闭源文件
+func (ir *IndexedReader) Close() error {
+	ir.r.Close()
+	return ir.f.Close()
+}
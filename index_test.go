@@ -0,0 +1,166 @@
+package charconv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// indexTestText 生成n行包含中文的测试文本
+func indexTestText(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "第%d行 line %d 世界，日本\n", i, i)
+	}
+	return sb.String()
+}
+
+func TestIndexedReader(t *testing.T) {
+	text := indexTestText(2000)
+	for _, charset := range []string{GBK, UTF16LE, ISO2022JP, UTF8} {
+		path := writeTestFile(t, text, charset)
+		idx, err := BuildIndex(path, charset, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idx.DecodedSize != int64(len(text)) || idx.Lines != 2000 || len(idx.Checkpoints) < 10 {
+			t.Fatal(charset, idx.DecodedSize, idx.Lines, len(idx.Checkpoints))
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ir, err := NewIndexedReader(f, idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, offset := range []int64{int64(len(text)) - 10, 12345, 0, 30000, int64(len(text))} {
+			if _, err := ir.Seek(offset, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 100)
+			n, err := io.ReadFull(ir, buf)
+			if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+				t.Fatal(charset, offset, err)
+			}
+			if want := text[offset:min(offset+100, int64(len(text)))]; string(buf[:n]) != want {
+				t.Fatalf("%s %d: %q != %q", charset, offset, buf[:n], want)
+			}
+		}
+
+		for _, line := range []int64{1500, 7, 0, 2000} {
+			offset, err := ir.SeekLine(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strings.Index(text, fmt.Sprintf("第%d行", line))
+			if line == 2000 {
+				want = len(text)
+			}
+			if offset != int64(want) {
+				t.Fatal(charset, line, offset, want)
+			}
+			rest, err := io.ReadAll(ir)
+			if err != nil || string(rest) != text[want:] {
+				t.Fatal(charset, line, err)
+			}
+		}
+		if _, err := ir.SeekLine(2001); err == nil {
+			t.Fatal(charset)
+		}
+		if err := ir.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIndexSidecar(t *testing.T) {
+	text := indexTestText(500)
+	path := writeTestFile(t, text, GB18030)
+	ir, err := OpenIndexed(path, GB18030)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := ir.Index()
+	ir.Close()
+
+	saved, err := LoadIndex(path + IndexSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Charset != idx.Charset || saved.DecodedSize != idx.DecodedSize || saved.Lines != idx.Lines ||
+		!saved.SrcModTime.Equal(idx.SrcModTime) || fmt.Sprint(saved.Checkpoints) != fmt.Sprint(idx.Checkpoints) {
+		t.Fatal(saved, idx)
+	}
+
+	// 源文件变化后重新建立索引
+	text += "追加的一行\n"
+	data, _ := EncodeStringToBytesWithCharset(text, 0, GB18030)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ir, err = OpenIndexed(path, GB18030)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if ir.Size() != int64(len(text)) || ir.Index().Lines != 501 {
+		t.Fatal(ir.Size(), ir.Index().Lines)
+	}
+	if _, err := ir.SeekLine(500); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := io.ReadAll(ir)
+	if string(rest) != "追加的一行\n" {
+		t.Fatal(string(rest))
+	}
+
+	if _, err := ReadIndex(bytes.NewReader([]byte("garbage"))); !errors.Is(err, ErrInvalidIndex) {
+		t.Fatal(err)
+	}
+
+	// 损坏的长度与数量不能导致panic或分配大量内存
+	header := append(binary.AppendUvarint([]byte(indexMagic), 3), "GBK"...)
+	corrupt := [][]byte{
+		binary.AppendUvarint([]byte(indexMagic), 1<<62),
+		binary.AppendUvarint([]byte(indexMagic), maxIndexCharsetLen+1),
+		binary.AppendUvarint(binary.AppendVarint(binary.AppendVarint(binary.AppendVarint(
+			binary.AppendVarint(header, 1<<62), 0), 0), 0), 1<<62),
+	}
+	// 检查点不在数据开头、偏移递减或超出数据范围
+	for _, cps := range [][]Checkpoint{
+		{{SrcOffset: 3, DecodedOffset: 5, Line: 1}},
+		{{}, {SrcOffset: 10, DecodedOffset: 10}, {SrcOffset: 5, DecodedOffset: 20}},
+		{{}, {SrcOffset: 200, DecodedOffset: 10}},
+		{{}, {SrcOffset: 10, DecodedOffset: 10, Line: 9}},
+	} {
+		bad := &Index{Charset: GBK, SrcSize: 100, DecodedSize: 100, Lines: 2, Checkpoints: cps}
+		buffer := MakeByteBuffer(0)
+		if _, err := bad.WriteTo(buffer); err != nil {
+			t.Fatal(err)
+		}
+		corrupt = append(corrupt, buffer.Bytes())
+	}
+	for i, data := range corrupt {
+		if _, err := ReadIndex(bytes.NewReader(data)); !errors.Is(err, ErrInvalidIndex) {
+			t.Fatal(i, err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseQuietly(f)
+	bad := *ir.Index()
+	bad.Checkpoints = []Checkpoint{{SrcOffset: 3, DecodedOffset: 5, Line: 1}}
+	if _, err := NewIndexedReader(f, &bad); !errors.Is(err, ErrInvalidIndex) {
+		t.Fatal(err)
+	}
+}
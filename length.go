@@ -0,0 +1,110 @@
+package charconv
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
+)
+
+// EncodedLen 计算UTF-8字符串s以charset编码后的长度，不产生编码结果。
+// 存在无法映射的字符或非法UTF-8序列时，按替代字符（一个字节）计入长度，并返回第一处问题对应的ErrUnmappableRune或ErrInvalidByteSequence。
+// 除有状态字符集首次使用外不分配内存
+func EncodedLen(s string, charset string) (int, error) {
+	rc, err := RuneCodecOf(charset)
+	if err != nil {
+		return 0, opError(OpEncode, "", err)
+	}
+	z := makeEncodedSizer(rc)
+	defer z.release()
+
+	ascii := z.enc == nil && rc.kind != kindUTF16LE && rc.kind != kindUTF16BE && asciiCompatible(rc.e)
+	var first error
+	for i := 0; i < len(s); {
+		if ascii {
+			n := asciiPrefix(unsafeBytes(s[i:]))
+			z.n += n
+			if i += n; i == len(s) {
+				break
+			}
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if err := z.add(s, i, r, size); err != nil {
+			if first == nil {
+				first = err
+			}
+			z.n++
+		}
+		i += size
+	}
+	return z.size(), opError(OpEncode, "", first)
+}
+
+// DecodedLen 计算charset编码的p解码为UTF-8后的长度，不产生解码结果。
+// 非法字节序列按U+FFFD计入长度，并返回第一处非法字节序列对应的ErrInvalidByteSequence；
+// UTF-8数据原样计入长度，与DecodeBytesToBytesWithCharset的结果一致。
+// 除有状态字符集外不分配内存
+func DecodedLen(p []byte, charset string) (int, error) {
+	rc, err := RuneCodecOf(charset)
+	if err != nil {
+		return 0, opError(OpDecode, "", err)
+	}
+	switch rc.kind {
+	case kindUTF8:
+		if i := invalidUTF8Index(p); i < len(p) {
+			return len(p), opError(OpDecode, "", invalidSequence(int64(i), p[i:i+1]))
+		}
+		return len(p), nil
+	case kindStateful:
+		return decodedLenStateful(rc, p)
+	}
+
+	ascii := rc.kind != kindUTF16LE && rc.kind != kindUTF16BE && asciiCompatible(rc.e)
+	n := 0
+	var first error
+	for i := 0; i < len(p); {
+		if ascii {
+			k := asciiPrefix(p[i:])
+			n += k
+			if i += k; i == len(p) {
+				break
+			}
+		}
+		r, size, ok := rc.decodeRune(p[i:])
+		if !ok && first == nil {
+			first = invalidSequence(int64(i), p[i:i+size])
+		}
+		if r == utf8.RuneError && ok {
+			n += rc.decodedSize(p[i : i+size])
+		} else {
+			n += utf8.RuneLen(r)
+		}
+		i += size
+	}
+	return n, opError(OpDecode, "", first)
+}
+
+// decodedLenStateful 通过解码器计算有状态字符集解码后的长度
+func decodedLenStateful(rc *RuneCodec, p []byte) (int, error) {
+	var first error
+	d := rc.decoder()
+	defer rc.putDecoder(d)
+	h := newDecodeHandler(d, PolicyDefault, HandlerFunc(func(e Event) {
+		if first == nil {
+			first = invalidSequence(e.Offset, e.Bytes)
+		}
+	}))
+
+	var buf [defaultBufferSize]byte
+	n := 0
+	for off := 0; ; {
+		nDst, nSrc, err := h.Transform(buf[:], p[off:], true)
+		n += nDst
+		off += nSrc
+		if err == nil {
+			return n, opError(OpDecode, "", first)
+		}
+		if err != transform.ErrShortDst || nDst == 0 && nSrc == 0 {
+			return n, opError(OpDecode, "", err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package charconv
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncodedLen(t *testing.T) {
+	text := "hello, 日本世界！\n" + strings.Repeat("abc中文", 10)
+	for _, charset := range []string{GBK, GB18030, Big5, UTF8, UTF16LE, UTF16, ISO2022JP, EUCJP} {
+		want, err := EncodeStringToBytesWithCharset(text, 0, charset)
+		if err != nil {
+			t.Fatal(charset, err)
+		}
+		if n, err := EncodedLen(text, charset); err != nil || n != len(want) {
+			t.Fatal(charset, n, len(want), err)
+		}
+	}
+
+	n, err := EncodedLen("ab你c好", ISO88591)
+	var target ErrUnmappableRune
+	if !errors.As(err, &target) || target.Offset != 2 || target.Rune != '你' || n != 5 {
+		t.Fatal(n, err)
+	}
+	if _, err := EncodedLen("a", "no-such-charset"); !errors.Is(err, ErrUnsupported) {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodedLen(t *testing.T) {
+	text := "hello, 日本世界！\n" + strings.Repeat("abc中文", 10) + "\U00020000"
+	for _, charset := range []string{GBK, GB18030, Big5, UTF8, UTF16BE, ISO2022JP, EUCJP, "HZGB2312"} {
+		src := text
+		if charset != GB18030 && charset != UTF8 && charset != UTF16BE {
+			src = strings.TrimSuffix(text, "\U00020000")
+		}
+		encoded, err := EncodeStringToBytesWithCharset(src, 0, charset)
+		if err != nil {
+			t.Fatal(charset, err)
+		}
+		if n, err := DecodedLen(encoded, charset); err != nil || n != len(src) {
+			t.Fatal(charset, n, len(src), err)
+		}
+	}
+
+	// Big5的88 62解码为U+00CA U+0304
+	if n, err := DecodedLen([]byte{0x88, 0x62, 'a'}, Big5); err != nil || n != 5 {
+		t.Fatal(n, err)
+	}
+
+	for _, c := range []struct {
+		charset string
+		p       []byte
+		offset  int64
+	}{
+		{GBK, []byte("ab\xff\xc4\xe3"), 2},
+		{UTF8, []byte("ab\xffcd"), 2},
+		{ISO2022JP, []byte("ab\x1b$B\x30"), 5},
+	} {
+		decoded, _ := DecodeBytesToBytesWithCharset(c.p, 0, c.charset)
+		n, err := DecodedLen(c.p, c.charset)
+		var target ErrInvalidByteSequence
+		if !errors.As(err, &target) || target.Offset != c.offset || n != len(decoded) {
+			t.Fatal(c.charset, n, len(decoded), err)
+		}
+	}
+}
+
+func TestEncodedLenAllocs(t *testing.T) {
+	text := strings.Repeat("hello, 你好世界！\U0001F600", 10)
+	encoded, _ := EncodeStringToBytesWithCharset(text, 0, GB18030)
+	EncodedLen(text, GB18030)
+	allocs := testing.AllocsPerRun(100, func() {
+		EncodedLen(text, GB18030)
+		DecodedLen(encoded, GB18030)
+	})
+	if allocs != 0 && !raceEnabled {
+		t.Fatal(allocs)
+	}
+}
@@ -0,0 +1,122 @@
+package charconv
+
+import (
+	"io"
+)
+
+// expansionSlack 输出不超过该字节数时不检查膨胀比例，避免BOM等固定开销使小数据误判
+const expansionSlack = 4096
+
+// LimitKind 超出的限制类型
+type LimitKind int
+
+const (
+	// LimitInput 输入字节数超出WithMaxInputBytes设置的上限
+	LimitInput LimitKind = iota
+	// LimitOutput 输出字节数超出WithMaxOutputBytes设置的上限
+	LimitOutput
+	// LimitExpansion 输出与输入的字节数之比超出WithMaxExpansion设置的上限
+	LimitExpansion
+	// LimitLine LineScanner读取到的行超出LineScanner.Buffer设置的最大长度
+	LimitLine
+)
+
+func (k LimitKind) String() string {
+	switch k {
+	case LimitInput:
+		return "input"
+	case LimitOutput:
+		return "output"
+	case LimitExpansion:
+		return "expansion"
+	case LimitLine:
+		return "line"
+	}
+	return "unknown"
+}
+
+// WithMaxInputBytes 设置最多读取的输入字节数，超出时返回ErrSizeLimit。n不大于0表示不限制
+func WithMaxInputBytes(n int64) Option {
+	return func(c *Converter) {
+		c.maxInput = n
+	}
+}
+
+// WithMaxOutputBytes 设置最多输出的字节数，超出时返回ErrSizeLimit。n不大于0表示不限制
+func WithMaxOutputBytes(n int64) Option {
+	return func(c *Converter) {
+		c.maxOutput = n
+	}
+}
+
+// WithMaxExpansion 设置输出与输入字节数之比的上限，超出时返回ErrSizeLimit。ratio不大于0表示不限制。
+// 流式转换时按已读取的输入计算，输出不超过4096字节时不检查
+func WithMaxExpansion(ratio float64) Option {
+	return func(c *Converter) {
+		c.maxExpansion = ratio
+	}
+}
+
+// limited 判断是否设置了大小限制
+func (c *Converter) limited() bool {
+	return c.maxInput > 0 || c.maxOutput > 0 || c.maxExpansion > 0
+}
+
+// checkInput 检查已读取的输入字节数in是否超出限制
+func (c *Converter) checkInput(in int64) error {
+	if c.maxInput > 0 && in > c.maxInput {
+		return sizeLimit(LimitInput, c.maxInput)
+	}
+	return nil
+}
+
+// outputLimit 返回读取in字节输入时允许的最大输出字节数及其对应的限制类型，max小于0表示不限制
+func (c *Converter) outputLimit(in int64) (max int64, kind LimitKind) {
+	max = -1
+	if c.maxOutput > 0 {
+		max, kind = c.maxOutput, LimitOutput
+	}
+	if c.maxExpansion > 0 {
+		n := int64(c.maxExpansion * float64(in))
+		if n < expansionSlack {
+			n = expansionSlack
+		}
+		if max < 0 || n < max {
+			max, kind = n, LimitExpansion
+		}
+	}
+	return max, kind
+}
+
+// limitedReader 统计读取的字节数，超出输入限制时返回错误
+type limitedReader struct {
+	r io.Reader
+	c *Converter
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if lerr := l.c.checkInput(l.n); lerr != nil {
+		return 0, lerr
+	}
+	return n, err
+}
+
+// limitedWriter 写入前检查输出是否超出限制，in为已读取的输入字节数
+type limitedWriter struct {
+	w  io.Writer
+	c  *Converter
+	in *int64
+	n  int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if max, kind := l.c.outputLimit(*l.in); max >= 0 && l.n+int64(len(p)) > max {
+		return 0, sizeLimit(kind, max)
+	}
+	n, err := l.w.Write(p)
+	l.n += int64(n)
+	return n, err
+}
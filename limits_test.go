@@ -0,0 +1,68 @@
+package charconv
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMaxInputBytes(t *testing.T) {
+	c, err := NewConverter(WithSourceCharset(GBK), WithMaxInputBytes(int64(len(gbkData)-1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = c.ConvertBytes(gbkData)
+	var target ErrSizeLimit
+	if !errors.As(err, &target) || target.Kind != LimitInput || !errors.Is(err, ErrLimitExceeded) {
+		t.Fatal(err)
+	}
+	_, err = c.Convert(bytes.NewReader(gbkData), MakeByteBuffer(0))
+	if !errors.As(err, &target) || target.Kind != LimitInput {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	src := strings.Repeat(utf8String, 1000)
+	c, err := NewConverter(WithTargetCharset(UTF16LE), WithMaxOutputBytes(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, err := c.AppendString(nil, src)
+	var target ErrSizeLimit
+	if !errors.As(err, &target) || target.Kind != LimitOutput || target.Limit != 100 || cap(dest) > 1000 {
+		t.Fatal(err, cap(dest))
+	}
+
+	buffer := MakeByteBuffer(0)
+	_, err = c.Convert(strings.NewReader(src), buffer)
+	if !errors.As(err, &target) || target.Kind != LimitOutput || buffer.Len() > 100 {
+		t.Fatal(err, buffer.Len())
+	}
+
+	// 未超出限制时正常转换
+	dest, err = c.AppendString(nil, utf8String)
+	if err != nil || len(dest) != 10 {
+		t.Fatal(dest, err)
+	}
+}
+
+func TestMaxExpansion(t *testing.T) {
+	// 4字节的UTF-8字符转换为UTF-16代理对后同样为4字节，输出与输入之比为1
+	src := strings.Repeat("\U0001F600", 10000)
+	c, err := NewConverter(WithSourceCharset(UTF8), WithTargetCharset(UTF16LE), WithMaxExpansion(0.4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Convert(strings.NewReader(src), MakeByteBuffer(0))
+	var target ErrSizeLimit
+	if !errors.As(err, &target) || target.Kind != LimitExpansion {
+		t.Fatal(err)
+	}
+
+	// 小数据不检查膨胀比例
+	if _, err = c.AppendString(nil, "a"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,338 @@
+package charconv
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// DefaultMaxLineSize LineScanner默认的最大行长度，即解码后不包括行结束符的UTF-8文本的字节数
+const DefaultMaxLineSize = 1 << 20
+
+// LineTerminator 行结束符
+type LineTerminator int
+
+const (
+	// TerminatorNone 没有行结束符，仅出现在最后一行
+	TerminatorNone LineTerminator = iota
+	// TerminatorLF \n
+	TerminatorLF
+	// TerminatorCRLF \r\n
+	TerminatorCRLF
+	// TerminatorCR \r
+	TerminatorCR
+	// TerminatorNEL U+0085
+	TerminatorNEL
+	// TerminatorLS U+2028
+	TerminatorLS
+	// TerminatorPS U+2029
+	TerminatorPS
+)
+
+func (t LineTerminator) String() string {
+	switch t {
+	case TerminatorNone:
+		return "none"
+	case TerminatorLF:
+		return "LF"
+	case TerminatorCRLF:
+		return "CRLF"
+	case TerminatorCR:
+		return "CR"
+	case TerminatorNEL:
+		return "NEL"
+	case TerminatorLS:
+		return "LS"
+	case TerminatorPS:
+		return "PS"
+	}
+	return "unknown"
+}
+
+// Bytes 返回行结束符的UTF-8编码
+func (t LineTerminator) Bytes() []byte {
+	switch t {
+	case TerminatorLF:
+		return []byte("\n")
+	case TerminatorCRLF:
+		return []byte("\r\n")
+	case TerminatorCR:
+		return []byte("\r")
+	case TerminatorNEL:
+		return []byte("\u0085")
+	case TerminatorLS:
+		return []byte("\u2028")
+	case TerminatorPS:
+		return []byte("\u2029")
+	}
+	return nil
+}
+
+// Line LineScanner读取到的一行
+type Line struct {
+	// Text 解码后的UTF-8文本，不包括行结束符，仅在下一次调用Scan之前有效
+	Text []byte
+	// Number 行号，从1开始
+	Number int64
+	// Offset 行首在源数据中的字节偏移
+	Offset int64
+	// Terminator 原始的行结束符
+	Terminator LineTerminator
+}
+
+// LineScanner 逐行读取任意字符集编码的数据，在Unicode域中识别行结束符，因此同样适用于UTF-16等编码
+type LineScanner struct {
+	r     io.Reader
+	op    string
+	dh    *decodeHandler
+	track offsetTrack
+
+	src        []byte
+	src0, src1 int
+	eof        bool
+	// done 所有数据均已解码到text中
+	done bool
+
+	// text 已解码、尚未返回的文本，textBase为text[0]在解码结果中的偏移
+	text     []byte
+	textBase int64
+	// consumed 上一行（包括行结束符）在text中的长度，下一次Scan时丢弃
+	consumed int
+	// maxLine 最大行长度
+	maxLine int
+	scanned bool
+
+	line Line
+	err  error
+}
+
+// NewLineScanner 创建从r读取charset编码数据的LineScanner，opts可设置错误策略、事件回调等Converter选项。
+// charset不能为AutoDetect
+func NewLineScanner(r io.Reader, charset string, opts ...Option) (*LineScanner, error) {
+	c, err := NewConverter(append([]Option{withOp(OpDecode), WithSourceCharset(charset)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	if c.decoder == nil && c.srcCharset == AutoDetect {
+		return nil, opError(c.op, "", unsupported(AutoDetect))
+	}
+	decoder, _ := c.codecs(c.srcCharset)
+	if decoder == nil {
+		// 通过UTF-8解码器处理非法序列，同时得到逐字符的偏移
+		decoder = unicode.UTF8.NewDecoder()
+	}
+	s := &LineScanner{
+		r:       r,
+		op:      c.op,
+		dh:      newDecodeHandler(decoder, c.policy, c.handler),
+		src:     make([]byte, max(c.bufferSize, defaultBufferSize)),
+		text:    make([]byte, 0, defaultBufferSize),
+		maxLine: DefaultMaxLineSize,
+	}
+	s.dh.track = &s.track
+	s.dh.Reset()
+	return s, nil
+}
+
+// Buffer 设置读取时使用的初始缓冲区buf及最大行长度max，默认分别为内部分配的缓冲区及DefaultMaxLineSize。
+// 行长度按解码后不包括行结束符的UTF-8文本计算，超出max时Scan返回false，Err返回Kind为LimitLine的ErrSizeLimit。
+// 必须在第一次调用Scan之前调用，否则panic
+func (s *LineScanner) Buffer(buf []byte, max int) {
+	if s.scanned {
+		panic("charconv: Buffer called after Scan")
+	}
+	s.text = buf[:0]
+	s.maxLine = max
+}
+
+// Scan 读取下一行，没有更多的行或发生错误时返回false
+func (s *LineScanner) Scan() bool {
+	s.scanned = true
+	if s.err != nil {
+		return false
+	}
+	if s.consumed > 0 {
+		s.textBase += int64(s.consumed)
+		s.text = s.text[:copy(s.text, s.text[s.consumed:])]
+		s.consumed = 0
+	}
+
+	for pos := 0; ; {
+		end, size, term := findTerminator(s.text, pos, s.done)
+		if end < 0 && s.done && len(s.text) > 0 {
+			end, size, term = len(s.text), 0, TerminatorNone
+		}
+		if end > s.maxLine || end < 0 && len(s.text)-1 > s.maxLine {
+			// 未找到行结束符时，末尾可能是尚未确定的\r
+			s.err = opError(s.op, "", sizeLimit(LimitLine, int64(s.maxLine)))
+			return false
+		}
+		if end >= 0 {
+			s.emit(end, size, term)
+			return true
+		}
+		if s.done {
+			s.err = io.EOF
+			return false
+		}
+		// \r位于末尾时需要更多数据才能判断是否为\r\n
+		pos = max(len(s.text)-1, 0)
+		if err := s.fill(); err != nil {
+			s.err = err
+			return false
+		}
+	}
+}
+
+// emit 将text[:end]作为一行返回，其后size字节为行结束符term
+func (s *LineScanner) emit(end, size int, term LineTerminator) {
+	start := s.textBase
+	s.line = Line{
+		Text:       s.text[:end],
+		Number:     s.line.Number + 1,
+		Offset:     s.track.sourceOffset(start),
+		Terminator: term,
+	}
+	if s.line.Number == 1 {
+		// 去除开头的BOM
+		s.line.Text = bytes.TrimPrefix(s.line.Text, utf8BOM)
+	}
+	s.consumed = end + size
+	s.track.discard(start + int64(s.consumed))
+}
+
+// findTerminator 从pos开始查找行结束符，返回其位置、长度及类型，未找到时返回-1。
+// atEOF为false时，位于末尾的\r不作为结束符
+func findTerminator(text []byte, pos int, atEOF bool) (int, int, LineTerminator) {
+	for i := pos; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			return i, 1, TerminatorLF
+		case '\r':
+			if i+1 < len(text) {
+				if text[i+1] == '\n' {
+					return i, 2, TerminatorCRLF
+				}
+				return i, 1, TerminatorCR
+			}
+			if atEOF {
+				return i, 1, TerminatorCR
+			}
+			return -1, 0, TerminatorNone
+		case 0xC2:
+			// 解码结果均为完整的UTF-8字符
+			if i+1 < len(text) && text[i+1] == 0x85 {
+				return i, 2, TerminatorNEL
+			}
+		case 0xE2:
+			if i+2 < len(text) && text[i+1] == 0x80 {
+				switch text[i+2] {
+				case 0xA8:
+					return i, 3, TerminatorLS
+				case 0xA9:
+					return i, 3, TerminatorPS
+				}
+			}
+		}
+	}
+	return -1, 0, TerminatorNone
+}
+
+// fill 解码更多数据追加到text中
+func (s *LineScanner) fill() error {
+	for {
+		if s.src0 < s.src1 || s.eof {
+			if cap(s.text)-len(s.text) < defaultBufferSize/4 {
+				text := make([]byte, len(s.text), 2*cap(s.text)+defaultBufferSize)
+				copy(text, s.text)
+				s.text = text
+			}
+			nDst, nSrc, err := s.dh.Transform(s.text[len(s.text):cap(s.text)], s.src[s.src0:s.src1], s.eof)
+			s.text = s.text[:len(s.text)+nDst]
+			s.src0 += nSrc
+			switch {
+			case err == nil:
+				if s.eof {
+					s.done = true
+					return nil
+				}
+			case err == transform.ErrShortDst && (nDst > 0 || nSrc > 0):
+			case err == transform.ErrShortSrc && !s.eof:
+				if s.src1-s.src0 == len(s.src) {
+					return opError(s.op, "", err)
+				}
+			default:
+				return opError(s.op, "", err)
+			}
+			if nDst > 0 {
+				return nil
+			}
+		}
+
+		if s.src0 > 0 {
+			s.src1 = copy(s.src, s.src[s.src0:s.src1])
+			s.src0 = 0
+		}
+		n, err := s.r.Read(s.src[s.src1:])
+		s.src1 += n
+		if err == io.EOF {
+			s.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Line 返回最近一次Scan读取到的行
+func (s *LineScanner) Line() Line {
+	return s.line
+}
+
+// Bytes 返回最近一次Scan读取到的行的文本，仅在下一次调用Scan之前有效
+func (s *LineScanner) Bytes() []byte {
+	return s.line.Text
+}
+
+// Text 以字符串形式返回最近一次Scan读取到的行的文本
+func (s *LineScanner) Text() string {
+	return string(s.line.Text)
+}
+
+// Err 返回读取过程中遇到的第一个错误，正常结束时返回nil
+func (s *LineScanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// ForEachLine 逐行读取charset编码的r并调用fn，fn返回错误时停止并返回该错误
+func ForEachLine(r io.Reader, charset string, fn func(line Line) error, opts ...Option) error {
+	s, err := NewLineScanner(r, charset, opts...)
+	if err != nil {
+		return err
+	}
+	for s.Scan() {
+		if err := fn(s.Line()); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// ForEachLineInFile 逐行读取charset编码的文件srcFilePath并调用fn，参见ForEachLine
+func ForEachLineInFile(srcFilePath string, charset string, fn func(line Line) error, opts ...Option) error {
+	f, err := os.Open(srcFilePath)
+	if err != nil {
+		return opError(OpDecode, srcFilePath, err)
+	}
+	defer CloseQuietly(f)
+	err = ForEachLine(f, charset, fn, opts...)
+	if e, ok := err.(*OpError); ok && e.Path == "" {
+		return opError(OpDecode, srcFilePath, err)
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package charconv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestLineScanner(t *testing.T) {
+	text := "第一行\r\n第二行\n\r第四行\u0085第五行\u2028第六行\u2029最后一行"
+	want := []struct {
+		text string
+		term LineTerminator
+	}{
+		{"第一行", TerminatorCRLF},
+		{"第二行", TerminatorLF},
+		{"", TerminatorCR},
+		{"第四行", TerminatorNEL},
+		{"第五行", TerminatorLS},
+		{"第六行", TerminatorPS},
+		{"最后一行", TerminatorNone},
+	}
+	for _, charset := range []string{UTF8, UTF16LE, UTF16BE, UTF32LE, UTF32BE, GB18030} {
+		data, err := EncodeStringToBytesWithCharset(text, 0, charset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 每次只读取一个字节，行结束符和字符都会跨越读取边界
+		s, err := NewLineScanner(iotest.OneByteReader(bytes.NewReader(data)), charset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var offset int64
+		i := 0
+		for ; s.Scan(); i++ {
+			line := s.Line()
+			if i >= len(want) || string(line.Text) != want[i].text || line.Terminator != want[i].term || line.Number != int64(i+1) {
+				t.Fatal(charset, i, line.Text, line.Terminator)
+			}
+			if line.Offset != offset {
+				t.Fatal(charset, i, line.Offset, offset)
+			}
+			lineBytes, _ := EncodeStringToBytesWithCharset(want[i].text+string(want[i].term.Bytes()), 0, charset)
+			offset += int64(len(lineBytes))
+		}
+		if s.Err() != nil || i != len(want) {
+			t.Fatal(charset, i, s.Err())
+		}
+	}
+}
+
+func TestLineScannerBOM(t *testing.T) {
+	var lines []string
+	err := ForEachLine(strings.NewReader("\uFEFFa\nb\n"), UTF8, func(line Line) error {
+		lines = append(lines, fmt.Sprintf("%s@%d", line.Text, line.Offset))
+		return nil
+	})
+	if err != nil || fmt.Sprint(lines) != "[a@0 b@5]" {
+		t.Fatal(lines, err)
+	}
+}
+
+func TestLineScannerMaxLine(t *testing.T) {
+	// 长度恰好为上限的行可以读取，\r\n跨越读取边界
+	src := "0123456789\r\n" + strings.Repeat("x", 11) + "\nz"
+	s, err := NewLineScanner(iotest.OneByteReader(strings.NewReader(src)), UTF8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Buffer(make([]byte, 0, 4), 10)
+	if !s.Scan() || s.Text() != "0123456789" || s.Line().Terminator != TerminatorCRLF {
+		t.Fatal(s.Text(), s.Err())
+	}
+	var limit ErrSizeLimit
+	if s.Scan() || !errors.Is(s.Err(), ErrLimitExceeded) || !errors.As(s.Err(), &limit) || limit.Kind != LimitLine {
+		t.Fatal(s.Text(), s.Err())
+	}
+
+	// 没有行结束符的数据不会全部读入内存
+	s, err = NewLineScanner(strings.NewReader(strings.Repeat("x", 1<<24)), UTF8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Scan() || !errors.Is(s.Err(), ErrLimitExceeded) || cap(s.text) > 4*DefaultMaxLineSize {
+		t.Fatal(cap(s.text), s.Err())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Buffer after Scan should panic")
+		}
+	}()
+	s.Buffer(nil, 10)
+}
+
+func TestForEachLineInFile(t *testing.T) {
+	var lines []string
+	err := ForEachLineInFile("./test/test_gbk.txt", GBK, func(line Line) error {
+		lines = append(lines, string(line.Text))
+		return nil
+	})
+	if err != nil || strings.Join(lines, "\n") != strings.TrimRight(utf8String, "\n") && strings.Join(lines, "\n") != utf8String {
+		t.Fatal(lines, err)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = ForEachLine(strings.NewReader("a\nb\nc"), UTF8, func(line Line) error {
+		if n++; n == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != 2 {
+		t.Fatal(err, n)
+	}
+
+	err = ForEachLine(bytes.NewReader([]byte("a\n\xff")), GBK, func(line Line) error { return nil }, WithErrorPolicy(PolicyStrict))
+	var invalid ErrInvalidByteSequence
+	if !errors.As(err, &invalid) || invalid.Offset != 2 {
+		t.Fatal(err)
+	}
+	if _, err := NewLineScanner(strings.NewReader(""), AutoDetect); !errors.Is(err, ErrUnsupported) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,25 @@
+package charconv
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+var logger atomic.Pointer[slog.Logger]
+
+// SetLogger 设置包内部诊断信息（如关闭、删除临时文件失败）使用的日志记录器。
+// 默认不输出任何日志，传入nil可恢复默认行为
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+// Logger 返回当前设置的日志记录器，未设置时返回nil
+func Logger() *slog.Logger {
+	return logger.Load()
+}
+
+func logError(msg string, args ...any) {
+	if l := logger.Load(); l != nil {
+		l.Error(msg, args...)
+	}
+}
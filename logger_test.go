@@ -0,0 +1,30 @@
+package charconv
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetLogger(t *testing.T) {
+	out := MakeByteBuffer(0)
+	SetLogger(slog.New(slog.NewTextHandler(out, nil)))
+	defer SetLogger(nil)
+
+	file, err := MakeTempFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	RemoveQuietly(file)
+	// 再次关闭、删除同一文件必然失败
+	RemoveQuietly(file)
+
+	log := out.String()
+	if !strings.Contains(log, "error on closing file") || !strings.Contains(log, "error on remove file") {
+		t.Fatal(log)
+	}
+	if !bytes.Contains(out.Bytes(), []byte(file.Name())) {
+		t.Fatal(log)
+	}
+}
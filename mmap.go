@@ -0,0 +1,61 @@
+package charconv
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+)
+
+// SmallFileSize 不超过该大小的源文件在转换到文件时直接在内存中完成转换，不经过临时文件
+const SmallFileSize = 1 << 20
+
+// WithMmap 设置转换文件时是否将源文件映射到内存（仅Linux），由映射直接向Transformer提供数据，
+// 减少read系统调用和数据拷贝。不支持的平台、文件大小超出int的范围或映射失败时回退到流式读取，不会将大文件整个读入内存。
+// 启用时源文件在转换过程中不能被修改：文件被截断后访问映射会收到SIGBUS而导致程序崩溃
+func WithMmap(enable bool) Option {
+	return func(c *Converter) {
+		c.mmap = enable
+	}
+}
+
+// readSource 读取源文件f的全部内容（共size字节），启用WithMmap时使用内存映射，不能映射时读入内存。
+// 使用完毕后必须调用release
+func (c *Converter) readSource(f *os.File, size int64) (data []byte, release func(), err error) {
+	if c.mmap && size > 0 {
+		if data, release, err = mapSource(f, size); err == nil {
+			return data, release, nil
+		}
+	}
+	if size > math.MaxInt {
+		return nil, nil, errFileTooLarge
+	}
+	data = make([]byte, size)
+	_, err = io.ReadFull(f, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() {}, nil
+}
+
+// errFileTooLarge 文件大小超出int的范围，无法整个映射或读入内存
+var errFileTooLarge = errors.New("file too large")
+
+// mapSource 将源文件f的全部内容（共size字节）映射到内存，使用完毕后必须调用release。
+// 不支持的平台或映射失败时返回错误，调用者应回退到其他读取方式
+func mapSource(f *os.File, size int64) (data []byte, release func(), err error) {
+	if size > math.MaxInt {
+		// 32位平台上无法映射超过math.MaxInt字节的文件
+		return nil, nil, errFileTooLarge
+	}
+	data, err = mmapFile(f, size)
+	if err != nil {
+		logError("error on mmap file", "file", f.Name(), "error", err)
+		return nil, nil, err
+	}
+	return data, func() {
+		if err := munmap(data); err != nil {
+			logError("error on munmap file", "file", f.Name(), "error", err)
+		}
+	}, nil
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package charconv
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile 以只读方式将文件f的前size字节映射到内存
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmap 解除mmapFile建立的映射
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
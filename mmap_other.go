@@ -0,0 +1,17 @@
+//go:build !linux
+
+package charconv
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile 当前平台不支持内存映射，调用者应回退到普通读取
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,233 @@
+package charconv
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizationForm Unicode规范化形式，在解码之后、编码之前执行
+type NormalizationForm int
+
+const (
+	// NormalizationAuto 目标字符集为传统CJK字符集（GBK、HZ-GB-2312、Big5、EUC-JP、ISO-2022-JP、Shift_JIS、EUC-KR）时，
+	// 只对含有无法以目标字符集编码的字符的组合字符序列执行NFC，其余文本原样保留；
+	// 目标字符集为其他字符集时不做规范化（默认）。使用WithEncoder时无法得知目标字符集，不做规范化
+	NormalizationAuto NormalizationForm = iota
+	// NormalizationNone 不做规范化
+	NormalizationNone
+	// NormalizationNFC 规范分解后再规范组合
+	NormalizationNFC
+	// NormalizationNFD 规范分解
+	NormalizationNFD
+	// NormalizationNFKC 兼容分解后再规范组合
+	NormalizationNFKC
+	// NormalizationNFKD 兼容分解
+	NormalizationNFKD
+)
+
+func (f NormalizationForm) String() string {
+	switch f {
+	case NormalizationAuto:
+		return "auto"
+	case NormalizationNone:
+		return "none"
+	case NormalizationNFC:
+		return "NFC"
+	case NormalizationNFD:
+		return "NFD"
+	case NormalizationNFKC:
+		return "NFKC"
+	case NormalizationNFKD:
+		return "NFKD"
+	}
+	return "unknown"
+}
+
+// WithNormalization 设置Unicode规范化形式。
+// 例如macOS文件名等NFD文本中的"か"+"゙"在Shift_JIS、GBK中没有对应的编码，组合为"が"后才能编码。
+// 并行转换时分块在换行符处切分，只有不含换行符的超长行才可能在组合字符序列中间切分
+func WithNormalization(form NormalizationForm) Option {
+	return func(c *Converter) {
+		c.normalization = form
+	}
+}
+
+// normalizer 返回本次转换使用的规范化Transformer，不需要规范化时返回nil
+func (c *Converter) normalizer() *normTransformer {
+	if c.normalization == NormalizationAuto && c.encoder != nil {
+		return nil
+	}
+	return newNormTransformer(c.normalization, c.destCharset)
+}
+
+// newNormTransformer 返回执行form的normTransformer，NormalizationAuto根据目标字符集destCharset确定，不需要规范化时返回nil
+func newNormTransformer(form NormalizationForm, destCharset string) *normTransformer {
+	switch form {
+	case NormalizationAuto:
+		if legacyCJK(destCharset) {
+			if rc, err := RuneCodecOf(destCharset); err == nil {
+				return &normTransformer{form: norm.NFC, rc: rc}
+			}
+		}
+	case NormalizationNFC:
+		return &normTransformer{form: norm.NFC}
+	case NormalizationNFD:
+		return &normTransformer{form: norm.NFD}
+	case NormalizationNFKC:
+		return &normTransformer{form: norm.NFKC}
+	case NormalizationNFKD:
+		return &normTransformer{form: norm.NFKD}
+	}
+	return nil
+}
+
+// legacyCJK 判断charset是否为传统CJK字符集，这些字符集只收录组合后的字符。
+// GB18030可以编码所有字符，不需要规范化
+func legacyCJK(charset string) bool {
+	switch EncodingOf(charset) {
+	case simplifiedchinese.GBK, simplifiedchinese.HZGB2312,
+		traditionalchinese.Big5,
+		japanese.EUCJP, japanese.ISO2022JP, japanese.ShiftJIS,
+		korean.EUCKR:
+		return true
+	}
+	return false
+}
+
+// maxHeld held中最多缓存的数据量，超过时不再接收新的数据
+const maxHeld = defaultBufferSize
+
+// normTransformer 在Unicode域中执行规范化。
+// norm.Form在数据末尾保留最后一个可能与后续字符组合的片段并返回ErrShortSrc，
+// normTransformer将其缓存在held中，只在数据以不完整的UTF-8字符结尾时返回ErrShortSrc，与解码器的行为一致
+type normTransformer struct {
+	form norm.Form
+	// rc 不为nil时只规范化含有rc无法编码的字符的组合字符序列。
+	// 否则NFC会改写目标字符集本可以编码的字符，例如CJK兼容汉字会被替换为对应的统一汉字，导致无法还原
+	rc   *RuneCodec
+	held []byte
+}
+
+func (t *normTransformer) Reset() {
+	t.held = t.held[:0]
+}
+
+func (t *normTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	n := len(src)
+	if !atEOF {
+		n = fullRunes(src)
+	}
+	limited := false
+	if room := maxHeld - len(t.held); n > room {
+		n, limited = max(room, 0), true
+	}
+	t.held = append(t.held, src[:n]...)
+	nSrc = n
+
+	eof := atEOF && !limited
+	nDst, k, err := t.normalize(dst, t.held, eof)
+	t.held = t.held[:copy(t.held, t.held[k:])]
+	switch {
+	case err == transform.ErrShortDst:
+		return nDst, nSrc, err
+	case err != nil && err != transform.ErrShortSrc:
+		return nDst, nSrc, err
+	case limited:
+		// 输出空间不足导致held中积压了数据
+		return nDst, nSrc, transform.ErrShortDst
+	case nSrc < len(src):
+		return nDst, nSrc, transform.ErrShortSrc
+	}
+	return nDst, nSrc, nil
+}
+
+// normalize 规范化src，rc不为nil时原样保留可以编码的组合字符序列
+func (t *normTransformer) normalize(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	if t.rc == nil {
+		return t.form.Transform(dst, src, atEOF)
+	}
+	for nSrc < len(src) {
+		// 已经是规范化形式的部分规范化后不变，原样复制。不是数据末尾时，最后一个片段可能与后续数据组合
+		end := t.quickSpan(src, nSrc)
+		if end == len(src) && !atEOF {
+			end = nSrc + max(t.form.LastBoundary(src[nSrc:]), 0)
+		}
+		if end > nSrc {
+			n := copy(dst[nDst:], src[nSrc:end])
+			nDst += n
+			nSrc += n
+			if nSrc < end {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			continue
+		}
+
+		n := t.form.NextBoundary(src[nSrc:], atEOF)
+		if n < 0 {
+			return nDst, nSrc, transform.ErrShortSrc
+		}
+		seg := src[nSrc : nSrc+n]
+		if t.encodable(seg) {
+			if nDst+n > len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			nDst += copy(dst[nDst:], seg)
+		} else {
+			m, _, err := t.form.Transform(dst[nDst:], seg, true)
+			if err != nil {
+				return nDst, nSrc, err
+			}
+			nDst += m
+		}
+		nSrc += n
+	}
+	return nDst, nSrc, nil
+}
+
+// quickSpan 返回从src[start:]开始、规范化后不变的部分的结束位置。
+// ASCII字符之前总是片段边界，因此只需检查非ASCII部分及其前面的一个ASCII字符
+func (t *normTransformer) quickSpan(src []byte, start int) int {
+	end := start
+	for end < len(src) {
+		end += asciiPrefix(src[end:])
+		from := max(end-1, start)
+		stop := end
+		for stop < len(src) && src[stop] >= utf8.RuneSelf {
+			stop++
+		}
+		if n := t.form.QuickSpan(src[from:stop]); from+n < stop {
+			return from + n
+		}
+		end = stop
+	}
+	return end
+}
+
+// encodable 判断seg中的字符是否都可以用目标字符集编码
+func (t *normTransformer) encodable(seg []byte) bool {
+	for _, r := range string(seg) {
+		if r >= utf8.RuneSelf && t.rc.RuneLen(r) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// fullRunes 返回p中不包括末尾不完整UTF-8字符的长度
+func fullRunes(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if utf8.FullRune(p[i:]) {
+				return len(p)
+			}
+			return i
+		}
+	}
+	return len(p)
+}
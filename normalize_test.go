@@ -0,0 +1,174 @@
+package charconv
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestNormalizationAuto(t *testing.T) {
+	want, err := EncodeStringToBytesWithCharset("\u304c\u304e", 0, ShiftJIS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := MakeByteBuffer(0)
+	src := iotest.OneByteReader(strings.NewReader("\u304b\u3099\u304d\u3099"))
+	if err = ConvertBetweenCharsets(src, UTF8, dest, ShiftJIS); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dest.Bytes(), want) {
+		t.Fatal(dest.Bytes())
+	}
+
+	c, err := NewConverter(WithTargetCharset(ShiftJIS), WithNormalization(NormalizationNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = c.ConvertString("\u304b\u3099"); !errors.Is(err, ErrUnmappable) {
+		t.Fatal(err)
+	}
+
+	// 目标字符集不是传统CJK字符集时不做规范化
+	c, err = NewConverter(WithTargetCharset(UTF16LE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := c.ConvertString("\u304b\u3099")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 4 {
+		t.Fatalf("%q", out)
+	}
+}
+
+func TestNormalizationKeepsCompatIdeographs(t *testing.T) {
+	dest, err := EncodeStringToBytesWithCharset("\ufa0ca\u0301", 0, GBK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0xFE, 0x40, 0xA8, 0xA2}
+	if !bytes.Equal(dest, want) {
+		t.Fatal(dest)
+	}
+
+	// 显式指定NFC时按标准执行
+	c, err := NewConverter(WithNormalization(NormalizationNFC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := c.ConvertString("\ufa0c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "\u5140" {
+		t.Fatalf("%q", out)
+	}
+}
+
+func TestNormalizationAutoKeepsEncodable(t *testing.T) {
+	cases := []struct {
+		charset, src, want string
+	}{
+		// U+212B在Shift_JIS中可以编码，NFC得到的U+00C5却无法编码，只组合无法编码的序列
+		{ShiftJIS, "\u212b\u304b\u3099", "\u212b\u304c"},
+		// GB18030可以编码所有字符，不做规范化
+		{GB18030, "\u037e\u2126e\u0301", "\u037e\u2126e\u0301"},
+	}
+	for _, tc := range cases {
+		dest, err := EncodeStringToBytesWithCharset(tc.src, 0, tc.charset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := NewConverter(WithTargetCharset(tc.charset), WithNormalization(NormalizationNone))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _, err := c.ConvertString(tc.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(dest) != want {
+			t.Fatalf("%s: %x", tc.charset, dest)
+		}
+	}
+}
+
+func TestNormalizationForms(t *testing.T) {
+	cases := []struct {
+		form      NormalizationForm
+		src, want string
+	}{
+		{NormalizationNFC, "e\u0301", "\u00e9"},
+		{NormalizationNFD, "\u00e9\u304c", "e\u0301\u304b\u3099"},
+		{NormalizationNFKC, "\uff76\uff9e\u2460", "\u30ac1"},
+		{NormalizationNFKD, "\uff76\uff9e", "\u30ab\u3099"},
+	}
+	for _, tc := range cases {
+		c, err := NewConverter(WithNormalization(tc.form))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dest := MakeByteBuffer(0)
+		if _, err = c.Convert(iotest.OneByteReader(strings.NewReader(tc.src)), dest); err != nil {
+			t.Fatal(err)
+		}
+		if dest.String() != tc.want {
+			t.Fatalf("%s: %q", tc.form, dest.String())
+		}
+	}
+}
+
+func TestNormalizationWriter(t *testing.T) {
+	buffer := MakeByteBuffer(0)
+	w, err := NewWriter(buffer, UTF8, WithTargetCharset(GBK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"\u304b", "\u3099", "\xe3\x81", "\x8d\u3099"} {
+		if _, err = w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := EncodeStringToBytesWithCharset("\u304c\u304e", 0, GBK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffer.Bytes(), want) {
+		t.Fatal(buffer.Bytes())
+	}
+}
+
+func TestNormalizationFile(t *testing.T) {
+	src, err := EncodeStringToBytesWithCharset("\u304b\u3099\r\n", 0, UTF16LE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "nfd.txt")
+	destPath := filepath.Join(dir, "out.txt")
+	if err = os.WriteFile(srcPath, src, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ConvertFileBetweenCharsets(srcPath, UTF16LE, destPath, EUCJP, CreateOrTrunc); err != nil {
+		t.Fatal(err)
+	}
+	dest, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := EncodeStringToBytesWithCharset("\u304c\r\n", 0, EUCJP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dest, want) {
+		t.Fatal(dest)
+	}
+}
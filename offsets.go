@@ -0,0 +1,280 @@
+package charconv
+
+import (
+	"bytes"
+	"sort"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
+)
+
+// offsetSpan 一段步长一致的映射：第k个字符在编码数据中的偏移为enc+k*encStep，
+// 在UTF-8文本中的偏移为text+k*textStep，字符索引为runes+k*runeStep
+type offsetSpan struct {
+	enc, text, runes            int64
+	encStep, textStep, runeStep int
+	n                           int64
+}
+
+// OffsetMap 编码数据与其对应的UTF-8文本之间的偏移映射，以游程形式保存，按字符粒度定位。
+// 解码时编码数据为源数据，编码时编码数据为输出；行号和列号均从0开始，列号以字符计
+type OffsetMap struct {
+	spans []offsetSpan
+	// lines 除第一行外每行开头在UTF-8文本中的偏移
+	lines []int64
+
+	encLen, textLen, runes int64
+	// busy m正被WithOffsetMap设置的某次转换使用
+	busy atomic.Bool
+}
+
+// acquire 将m标记为正被一次转换使用，m已被使用时返回false
+func (m *OffsetMap) acquire() bool {
+	return m.busy.CompareAndSwap(false, true)
+}
+
+func (m *OffsetMap) release() {
+	m.busy.Store(false)
+}
+
+func (m *OffsetMap) reset() {
+	m.spans = m.spans[:0]
+	m.lines = m.lines[:0]
+	m.encLen, m.textLen, m.runes = 0, 0, 0
+}
+
+// add 记录一个字符：编码数据中的encLen字节对应UTF-8文本text
+func (m *OffsetMap) add(encLen int, text []byte) {
+	runes := utf8.RuneCount(text)
+	for i := bytes.IndexByte(text, '\n'); i >= 0; {
+		m.lines = append(m.lines, m.textLen+int64(i)+1)
+		j := bytes.IndexByte(text[i+1:], '\n')
+		if j < 0 {
+			break
+		}
+		i += j + 1
+	}
+
+	if k := len(m.spans); k > 0 {
+		last := &m.spans[k-1]
+		if last.encStep == encLen && last.textStep == len(text) && last.runeStep == runes {
+			last.n++
+			m.advance(encLen, len(text), runes)
+			return
+		}
+	}
+	m.spans = append(m.spans, offsetSpan{
+		enc: m.encLen, text: m.textLen, runes: m.runes,
+		encStep: encLen, textStep: len(text), runeStep: runes,
+		n: 1,
+	})
+	m.advance(encLen, len(text), runes)
+}
+
+func (m *OffsetMap) advance(encLen, textLen, runes int) {
+	m.encLen += int64(encLen)
+	m.textLen += int64(textLen)
+	m.runes += int64(runes)
+}
+
+// EncodedLen 返回编码数据的长度
+func (m *OffsetMap) EncodedLen() int64 {
+	return m.encLen
+}
+
+// TextLen 返回UTF-8文本的长度
+func (m *OffsetMap) TextLen() int64 {
+	return m.textLen
+}
+
+// RuneCount 返回UTF-8文本中的字符数
+func (m *OffsetMap) RuneCount() int64 {
+	return m.runes
+}
+
+// LineCount 返回UTF-8文本的行数，以\n分隔
+func (m *OffsetMap) LineCount() int64 {
+	return int64(len(m.lines)) + 1
+}
+
+// locate 在key(span)不大于off的最后一段中找到off所在的字符，返回该段及字符序号。
+// off超出范围时ok为false
+func (m *OffsetMap) locate(off int64, key func(s *offsetSpan) int64, step func(s *offsetSpan) int) (s *offsetSpan, k int64, ok bool) {
+	i := sort.Search(len(m.spans), func(i int) bool {
+		return key(&m.spans[i]) > off
+	})
+	if i == 0 {
+		return nil, 0, false
+	}
+	s = &m.spans[i-1]
+	if w := step(s); w > 0 {
+		k = (off - key(s)) / int64(w)
+	}
+	if k >= s.n {
+		return nil, 0, false
+	}
+	return s, k, true
+}
+
+func spanEnc(s *offsetSpan) int64   { return s.enc }
+func spanText(s *offsetSpan) int64  { return s.text }
+func spanRunes(s *offsetSpan) int64 { return s.runes }
+func encStep(s *offsetSpan) int     { return s.encStep }
+func textStep(s *offsetSpan) int    { return s.textStep }
+func runeStep(s *offsetSpan) int    { return s.runeStep }
+
+// clampOffset 将off限制在[0, limit]中，并报告off是否位于数据末尾或之后
+func clampOffset(off, limit int64) (int64, bool) {
+	if off < 0 {
+		return 0, false
+	}
+	if off >= limit {
+		return limit, true
+	}
+	return off, false
+}
+
+// TextOffset 返回编码数据中偏移为enc的字节所属字符在UTF-8文本中的起始偏移
+func (m *OffsetMap) TextOffset(enc int64) int64 {
+	enc, end := clampOffset(enc, m.encLen)
+	if end {
+		return m.textLen
+	}
+	s, k, ok := m.locate(enc, spanEnc, encStep)
+	if !ok {
+		return m.textLen
+	}
+	return s.text + k*int64(s.textStep)
+}
+
+// EncodedOffset 返回UTF-8文本中偏移为text的字节所属字符在编码数据中的起始偏移
+func (m *OffsetMap) EncodedOffset(text int64) int64 {
+	text, end := clampOffset(text, m.textLen)
+	if end {
+		return m.encLen
+	}
+	s, k, ok := m.locate(text, spanText, textStep)
+	if !ok {
+		return m.encLen
+	}
+	return s.enc + k*int64(s.encStep)
+}
+
+// RuneIndex 返回UTF-8文本中偏移为text的字节所属字符的索引
+func (m *OffsetMap) RuneIndex(text int64) int64 {
+	text, end := clampOffset(text, m.textLen)
+	if end {
+		return m.runes
+	}
+	s, k, ok := m.locate(text, spanText, textStep)
+	if !ok {
+		return m.runes
+	}
+	return s.runes + k*int64(s.runeStep)
+}
+
+// RuneOffset 返回索引为i的字符在UTF-8文本中的偏移。一个源字符解码为多个Unicode字符时，返回该源字符的起始偏移
+func (m *OffsetMap) RuneOffset(i int64) int64 {
+	i, end := clampOffset(i, m.runes)
+	if end {
+		return m.textLen
+	}
+	s, k, ok := m.locate(i, spanRunes, runeStep)
+	if !ok {
+		return m.textLen
+	}
+	return s.text + k*int64(s.textStep)
+}
+
+// Position 返回UTF-8文本中偏移为text的字节所属字符的行号和列号
+func (m *OffsetMap) Position(text int64) (line, column int64) {
+	text, _ = clampOffset(text, m.textLen)
+	line = int64(sort.Search(len(m.lines), func(i int) bool {
+		return m.lines[i] > text
+	}))
+	return line, m.RuneIndex(text) - m.RuneIndex(m.lineStart(line))
+}
+
+// LineOffset 返回第line行第column列的字符在UTF-8文本中的偏移，超出范围时返回最近的有效位置
+func (m *OffsetMap) LineOffset(line, column int64) int64 {
+	if line < 0 {
+		return 0
+	}
+	if line >= m.LineCount() {
+		return m.textLen
+	}
+	start := m.lineStart(line)
+	end := m.textLen
+	if line < int64(len(m.lines)) {
+		end = m.lines[line]
+	}
+	off := m.RuneOffset(m.RuneIndex(start) + max(column, 0))
+	return min(off, end)
+}
+
+// lineStart 返回第line行开头在UTF-8文本中的偏移
+func (m *OffsetMap) lineStart(line int64) int64 {
+	if line == 0 {
+		return 0
+	}
+	return m.lines[line-1]
+}
+
+// EncodedPosition 返回编码数据中偏移为enc的字节所属字符的行号和列号
+func (m *OffsetMap) EncodedPosition(enc int64) (line, column int64) {
+	return m.Position(m.TextOffset(enc))
+}
+
+// WithOffsetMap 转换时将源数据与解码得到的UTF-8文本之间的偏移映射记录到m中，每次转换开始时重置m。
+// 映射位于解码之后、BOM处理、清理、规范化、换行符转换及编码之前，与DecodeBytesWithOffsets的结果一致；源字符集为UTF-8时同样逐字符记录。
+// 适用于Converter、Reader、Writer及文件转换，启用后不做并行转换。
+// m同一时刻只能用于一次转换（Reader、Writer从创建到关闭期间一直占用m）：在同一个Converter上并发转换，
+// 或多个Reader、Writer共用m时，后开始的转换返回ErrOffsetMapInUse，m中只保留先开始的转换的结果
+func WithOffsetMap(m *OffsetMap) Option {
+	return func(c *Converter) {
+		c.offsets = m
+	}
+}
+
+// DecodeBytesWithOffsets 将srcCharset编码的src解码为UTF-8，同时返回源数据与解码结果之间的偏移映射
+func DecodeBytesWithOffsets(src []byte, srcCharset string) ([]byte, *OffsetMap, error) {
+	decoder := DecoderOf(srcCharset)
+	if decoder == nil {
+		return nil, nil, opError(OpDecode, "", unsupported(srcCharset))
+	}
+	m := &OffsetMap{}
+	h := newDecodeHandler(decoder, PolicyDefault, nil)
+	h.mapping = m
+	dst, _, err := transform.Bytes(h, src)
+	if err != nil {
+		return nil, nil, opError(OpDecode, "", err)
+	}
+	return dst, m, nil
+}
+
+// EncodeStringWithOffsets 将UTF-8字符串src编码为destCharset，同时返回src与编码结果之间的偏移映射。
+// ISO-2022-JP等有状态字符集的转义序列计入其后的字符，结尾恢复初始状态的转义序列计入最后一个字符
+func EncodeStringWithOffsets(src string, destCharset string) ([]byte, *OffsetMap, error) {
+	encoder := EncoderOf(destCharset)
+	if encoder == nil {
+		return nil, nil, opError(OpEncode, "", unsupported(destCharset))
+	}
+	m := &OffsetMap{}
+	h := newEncodeHandler(encoder, PolicyDefault, nil)
+	h.Reset()
+	dst := make([]byte, 0, len(src))
+	var buf [minHandlerDst]byte
+	for i := 0; i < len(src); {
+		_, size := utf8.DecodeRuneInString(src[i:])
+		p := unsafeBytes(src[i : i+size])
+		n, _, err := h.Transform(buf[:], p, i+size == len(src))
+		if err != nil {
+			return nil, nil, opError(OpEncode, "", err)
+		}
+		dst = append(dst, buf[:n]...)
+		m.add(n, p)
+		i += size
+	}
+	return dst, m, nil
+}
@@ -0,0 +1,174 @@
+package charconv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecodeBytesWithOffsets(t *testing.T) {
+	text := "ab你好\nline2 世界\r\n末行"
+	src, _ := EncodeStringToBytesWithCharset(text, 0, GBK)
+	dst, m, err := DecodeBytesWithOffsets(src, GBK)
+	if err != nil || string(dst) != text {
+		t.Fatal(string(dst), err)
+	}
+	if m.EncodedLen() != int64(len(src)) || m.TextLen() != int64(len(text)) || m.RuneCount() != 17 || m.LineCount() != 3 {
+		t.Fatal(m.EncodedLen(), m.TextLen(), m.RuneCount(), m.LineCount())
+	}
+
+	// "好"在GBK中位于偏移4，在UTF-8中位于偏移5
+	hao := int64(strings.Index(text, "好"))
+	if m.EncodedOffset(hao) != 4 || m.EncodedOffset(hao+2) != 4 || m.TextOffset(4) != hao || m.TextOffset(5) != hao {
+		t.Fatal(m.EncodedOffset(hao), m.TextOffset(4))
+	}
+	if m.RuneIndex(hao) != 3 || m.RuneOffset(3) != hao {
+		t.Fatal(m.RuneIndex(hao), m.RuneOffset(3))
+	}
+
+	shi := int64(strings.Index(text, "世"))
+	if line, col := m.Position(shi); line != 1 || col != 6 {
+		t.Fatal(line, col)
+	}
+	if line, col := m.EncodedPosition(m.EncodedOffset(shi)); line != 1 || col != 6 {
+		t.Fatal(line, col)
+	}
+	if m.LineOffset(1, 6) != shi || m.LineOffset(2, 0) != int64(strings.Index(text, "末")) || m.LineOffset(0, 100) != 9 {
+		t.Fatal(m.LineOffset(1, 6), m.LineOffset(2, 0), m.LineOffset(0, 100))
+	}
+	if m.TextOffset(int64(len(src))) != int64(len(text)) || m.EncodedOffset(1000) != int64(len(src)) {
+		t.Fatal()
+	}
+}
+
+func TestDecodeBytesWithOffsetsInvalid(t *testing.T) {
+	// 非法字节替换为U+FFFD，映射仍然按源字符对齐
+	dst, m, err := DecodeBytesWithOffsets([]byte{'a', 0xFF, 'b'}, UTF8)
+	if err != nil || string(dst) != "a\uFFFDb" {
+		t.Fatal(string(dst), err)
+	}
+	if m.TextOffset(2) != 4 || m.EncodedOffset(4) != 2 || m.RuneIndex(4) != 2 {
+		t.Fatal(m.TextOffset(2), m.EncodedOffset(4))
+	}
+}
+
+func TestEncodeStringWithOffsets(t *testing.T) {
+	text := "abc日本語\nxyz"
+	dst, m, err := EncodeStringWithOffsets(text, ISO2022JP)
+	if want, _ := EncodeStringToBytesWithCharset(text, 0, ISO2022JP); err != nil || string(dst) != string(want) {
+		t.Fatal(dst, err)
+	}
+	// 切换到JIS X 0208的转义序列计入"日"
+	ri := int64(strings.Index(text, "日"))
+	if m.EncodedOffset(ri) != 3 || m.TextOffset(3) != ri || m.TextOffset(5) != ri || m.EncodedOffset(ri+3) != 8 {
+		t.Fatal(m.EncodedOffset(ri), m.TextOffset(5), m.EncodedOffset(ri+3))
+	}
+	if m.EncodedLen() != int64(len(dst)) {
+		t.Fatal(m.EncodedLen())
+	}
+
+	if _, _, err := EncodeStringWithOffsets("a你", ISO88591); !errors.Is(err, ErrUnmappable) {
+		t.Fatal(err)
+	}
+	if _, _, err := EncodeStringWithOffsets("a", "no-such-charset"); !errors.Is(err, ErrUnsupported) {
+		t.Fatal(err)
+	}
+}
+
+// sameOffsets 比较m与want的长度及每个编码偏移对应的文本偏移
+func sameOffsets(m, want *OffsetMap) bool {
+	if m.EncodedLen() != want.EncodedLen() || m.TextLen() != want.TextLen() || m.RuneCount() != want.RuneCount() || m.LineCount() != want.LineCount() {
+		return false
+	}
+	for off := int64(0); off <= want.EncodedLen(); off++ {
+		if m.TextOffset(off) != want.TextOffset(off) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWithOffsetMap(t *testing.T) {
+	text := strings.Repeat("ab你好\nline2 世界\r\n", 50)
+	src, _ := EncodeStringToBytesWithCharset(text, 0, GBK)
+	_, want, err := DecodeBytesWithOffsets(src, GBK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m OffsetMap
+	c, err := NewConverter(WithSourceCharset(GBK), WithTargetCharset(UTF16LE), WithNewlinePolicy(NewlineToLF), WithOffsetMap(&m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = c.ConvertBytes(src); err != nil || !sameOffsets(&m, want) {
+		t.Fatal(m.EncodedLen(), m.TextLen(), err)
+	}
+	if _, err = c.Convert(iotest.OneByteReader(bytes.NewReader(src)), io.Discard); err != nil || !sameOffsets(&m, want) {
+		t.Fatal(m.EncodedLen(), m.TextLen(), err)
+	}
+
+	// Reader、Writer
+	r, err := NewReader(iotest.OneByteReader(bytes.NewReader(src)), GBK, WithOffsetMap(&m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := io.ReadAll(r); err != nil || string(out) != text || !sameOffsets(&m, want) {
+		t.Fatal(m.EncodedLen(), err)
+	}
+
+	// Reader关闭之前m一直被占用，其他转换不能同时使用m
+	if _, _, err = c.ConvertBytes(src); !errors.Is(err, ErrOffsetMapInUse) {
+		t.Fatal(err)
+	}
+	r2, err := NewReader(bytes.NewReader(src), GBK, WithOffsetMap(&m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadAll(r2); !errors.Is(err, ErrOffsetMapInUse) || !sameOffsets(&m, want) {
+		t.Fatal(err)
+	}
+	r.Close()
+	// 占用m的转换结束后可以重新使用
+	r2.Reset(bytes.NewReader(src))
+	if out, err := io.ReadAll(r2); err != nil || string(out) != text || !sameOffsets(&m, want) {
+		t.Fatal(err)
+	}
+	r2.Close()
+
+	buffer := MakeByteBuffer(0)
+	w, err := NewWriter(buffer, UTF8, WithTargetCharset(GBK), WithOffsetMap(&m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(text); i += 7 {
+		if _, err = w.Write([]byte(text[i:min(i+7, len(text))])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil || !bytes.Equal(buffer.Bytes(), src) {
+		t.Fatal(err)
+	}
+	// 源字符集为UTF-8时编码数据即源数据
+	if m.EncodedLen() != int64(len(text)) || m.TextLen() != int64(len(text)) || m.TextOffset(5) != 5 {
+		t.Fatal(m.EncodedLen(), m.TextLen())
+	}
+
+	// 文件转换不做并行转换
+	path := filepath.Join(t.TempDir(), "src.txt")
+	if err = os.WriteFile(path, src, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err = NewConverter(WithSourceCharset(GBK), WithParallelism(4), WithChunkSize(64), WithOffsetMap(&m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ConvertFile(path, io.Discard); err != nil || !sameOffsets(&m, want) {
+		t.Fatal(m.EncodedLen(), err)
+	}
+}
@@ -0,0 +1,161 @@
+package charconv
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// WithTempDir 设置必须经过临时文件时（目标文件即源文件）临时文件所在的目录，默认为os.TempDir()
+func WithTempDir(dir string) Option {
+	return func(c *Converter) {
+		c.tempDir = dir
+	}
+}
+
+// WithAtomicWrite 设置输出到文件时是否先写入目标文件所在目录下的临时文件，完成后再原子地重命名为目标文件，默认不启用。
+// 启用时转换失败时目标文件保持不变，但目标文件会被替换为新文件，其硬链接、所有者及ACL等不会保留。
+// 只有destFileFlag同时包含os.O_CREATE和os.O_TRUNC，且目标文件不存在或为普通文件时才适用，否则仍直接写入目标文件。
+// 不启用时按destFileFlag打开并直接写入目标文件，转换失败时目标文件只包含部分结果
+func WithAtomicWrite(enable bool) Option {
+	return func(c *Converter) {
+		c.atomic = enable
+	}
+}
+
+// writeTo 按选项选择输出方式，将write的输出写入destPath。
+// viaTmp为true（目标文件即源文件）时先写入临时文件；否则按WithAtomicWrite写入同目录的临时文件后重命名，或直接写入目标文件
+func (c *Converter) writeTo(destPath string, destFlag int, viaTmp bool, write func(w io.Writer) error) error {
+	if viaTmp {
+		return writeViaTmpFile(c.op, c.tempDir, destPath, destFlag, write)
+	}
+	return writeOutput(c.op, destPath, destFlag, c.atomic, write)
+}
+
+// writeOutput 将write的输出写入destPath。atomic为true、destFlag包含os.O_CREATE|os.O_TRUNC且destPath可以被替换时使用writeAtomic，
+// 否则使用writeDirect。不创建或不截断目标文件时，替换目标文件与destFlag的语义不符
+func writeOutput(op string, destPath string, destFlag int, atomic bool, write func(w io.Writer) error) error {
+	const replaceFlag = os.O_CREATE | os.O_TRUNC
+	if atomic && destFlag&replaceFlag == replaceFlag && destFlag&os.O_APPEND == 0 && replaceable(destPath) {
+		return writeAtomic(op, destPath, destFlag, write)
+	}
+	return writeDirect(op, destPath, destFlag, write)
+}
+
+// replaceable 判断destPath是否可以被重命名的临时文件替换，即不存在或为普通文件。
+// 替换符号链接、设备、命名管道等会改变其含义
+func replaceable(destPath string) bool {
+	fi, err := os.Lstat(destPath)
+	if err != nil {
+		return errors.Is(err, os.ErrNotExist)
+	}
+	return fi.Mode().IsRegular()
+}
+
+// sameFile 判断f与path是否为同一文件，path不存在时返回false
+func sameFile(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	destFi, err := os.Stat(path)
+	return err == nil && os.SameFile(fi, destFi)
+}
+
+// destWriter 为写入目标文件时的错误记录目标文件路径
+type destWriter struct {
+	f  *os.File
+	op string
+}
+
+func (w destWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	return n, opError(w.op, w.f.Name(), err)
+}
+
+// closeFile 关闭f，将关闭错误合并到err中
+func closeFile(op string, f *os.File, err error) error {
+	if cerr := f.Close(); cerr != nil {
+		return errors.Join(err, opError(op, f.Name(), cerr))
+	}
+	return err
+}
+
+// writeDirect 以destFlag打开destPath，将write的输出直接写入其中。
+// 写入、同步及关闭目标文件过程中的错误均会返回
+func writeDirect(op string, destPath string, destFlag int, write func(w io.Writer) error) (err error) {
+	destFile, err := os.OpenFile(destPath, destFlag, 0666)
+	if err != nil {
+		return opError(op, destPath, err)
+	}
+	defer func() {
+		err = closeFile(op, destFile, err)
+	}()
+
+	err = write(destWriter{f: destFile, op: op})
+	if err != nil {
+		return opError(op, "", err)
+	}
+	return opError(op, destPath, destFile.Sync())
+}
+
+// writeAtomic 将write的输出写入destPath所在目录下的临时文件，完成后重命名为destPath。
+// destFlag包含os.O_EXCL且destPath已存在时返回错误
+func writeAtomic(op string, destPath string, destFlag int, write func(w io.Writer) error) (err error) {
+	tmpFile, err := createSiblingTemp(destPath)
+	if err != nil {
+		return opError(op, destPath, err)
+	}
+	tmpPath := tmpFile.Name()
+	defer func() {
+		if err != nil {
+			if rerr := os.Remove(tmpPath); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+				logError("error on remove file", "file", tmpPath, "error", rerr)
+			}
+		}
+	}()
+
+	err = write(destWriter{f: tmpFile, op: op})
+	if err != nil {
+		return closeFile(op, tmpFile, opError(op, "", err))
+	}
+	err = tmpFile.Sync()
+	if err != nil {
+		return closeFile(op, tmpFile, opError(op, destPath, err))
+	}
+	err = closeFile(op, tmpFile, nil)
+	if err != nil {
+		return err
+	}
+
+	if fi, serr := os.Stat(destPath); serr == nil {
+		// 保留目标文件原有的权限
+		if err = os.Chmod(tmpPath, fi.Mode().Perm()); err != nil {
+			return opError(op, destPath, err)
+		}
+	}
+	if destFlag&os.O_EXCL != 0 {
+		// 目标文件已存在时os.Link失败，不会覆盖目标文件
+		if err = os.Link(tmpPath, destPath); err != nil {
+			return opError(op, destPath, err)
+		}
+		return opError(op, destPath, os.Remove(tmpPath))
+	}
+	return opError(op, destPath, os.Rename(tmpPath, destPath))
+}
+
+// createSiblingTemp 在path所在目录下创建临时文件。与os.CreateTemp不同，新文件的权限受umask约束而不是固定为0600
+func createSiblingTemp(path string) (*os.File, error) {
+	dir, base := filepath.Split(path)
+	for i := 0; ; i++ {
+		name := filepath.Join(dir, "."+base+"."+strconv.FormatUint(uint64(rand.Uint32()), 10)+".tmp")
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return f, err
+	}
+}
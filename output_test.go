@@ -0,0 +1,154 @@
+package charconv
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvertToFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "dest.txt")
+	if err := os.WriteFile(dest, []byte("old"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewConverter(WithTargetCharset(GBK), WithAtomicWrite(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ConvertToFile(strings.NewReader(utf8String), dest, CreateOrTrunc); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil || !bytes.Equal(data, gbkData) {
+		t.Fatal(data, err)
+	}
+	fi, err := os.Stat(dest)
+	if err != nil || fi.Mode().Perm() != 0o640 {
+		t.Fatal(fi.Mode(), err)
+	}
+
+	// 转换失败时目标文件保持不变，且不留下临时文件
+	strict, err := NewConverter(WithTargetCharset(ISO88591), WithErrorPolicy(PolicyStrict), WithAtomicWrite(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = strict.ConvertToFile(strings.NewReader(utf8String), dest, CreateOrTrunc); !errors.Is(err, ErrUnmappable) {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(dest)
+	entries, _ := os.ReadDir(dir)
+	if !bytes.Equal(data, gbkData) || len(entries) != 1 {
+		t.Fatal(data, entries)
+	}
+
+	// 禁用时直接写入，转换失败时目标文件只包含部分结果
+	direct, err := NewConverter(WithTargetCharset(ISO88591), WithErrorPolicy(PolicyStrict), WithAtomicWrite(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = direct.ConvertToFile(strings.NewReader(utf8String), dest, CreateOrTrunc); !errors.Is(err, ErrUnmappable) {
+		t.Fatal(err)
+	}
+	if data, _ = os.ReadFile(dest); bytes.Equal(data, gbkData) {
+		t.Fatal(data)
+	}
+
+	// 目标文件已存在时os.O_EXCL失败
+	_, err = c.ConvertToFile(strings.NewReader(utf8String), dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if !errors.Is(err, os.ErrExist) {
+		t.Fatal(err)
+	}
+}
+
+func TestConvertFileToFileFlags(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	dest := filepath.Join(dir, "dest.txt")
+	if err := os.WriteFile(src, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, atomic := range []bool{false, true} {
+		c, err := NewConverter(WithTargetCharset(GBK), WithAtomicWrite(atomic))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 不含os.O_CREATE时不创建目标文件
+		_ = os.Remove(dest)
+		if _, err = c.ConvertFileToFile(src, dest, os.O_WRONLY); !errors.Is(err, os.ErrNotExist) {
+			t.Fatal(atomic, err)
+		}
+		// 不含os.O_TRUNC时覆盖目标文件开头的内容
+		if err = os.WriteFile(dest, []byte("0123456789"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.ConvertFileToFile(src, dest, os.O_WRONLY); err != nil {
+			t.Fatal(atomic, err)
+		}
+		if data, _ := os.ReadFile(dest); string(data) != "hello56789" {
+			t.Fatal(atomic, string(data))
+		}
+	}
+
+	// 默认直接写入，保留目标文件的硬链接
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Link(dest, link); err != nil {
+		t.Skip(err)
+	}
+	if err := ConvertFileBetweenCharsets(src, UTF8, dest, GBK, CreateOrTrunc); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(link); string(data) != "hello" {
+		t.Fatal(string(data))
+	}
+}
+
+func TestConvertFileToFileDirect(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "dest.txt")
+	// 目标文件与源文件不同时不使用临时文件，不存在的临时目录不影响转换
+	c, err := NewConverter(WithSourceCharset(GBK), WithTempDir(filepath.Join(dir, "missing")), WithAtomicWrite(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ConvertFileToFile("./test/test_gbk.txt", dest, CreateOrTrunc); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil || !bytes.Equal(data, utf8Data) {
+		t.Fatal(data, err)
+	}
+}
+
+func TestConvertFileToFileTempDir(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "in_place.txt")
+	text := strings.Repeat(utf8String+"\n", SmallFileSize/len(utf8String))
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 原地转换大文件时必须使用临时文件
+	c, err := NewConverter(WithTargetCharset(GBK), WithTempDir(filepath.Join(dir, "missing")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ConvertFileToFile(path, path, CreateOrTrunc); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+
+	c, err = NewConverter(WithTargetCharset(GBK), WithTempDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ConvertFileToFile(path, path, CreateOrTrunc); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if want, _ := EncodeStringToBytesWithCharset(text, 0, GBK); err != nil || !bytes.Equal(data, want) {
+		t.Fatal(len(data), err)
+	}
+}
@@ -0,0 +1,326 @@
+package charconv
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// DefaultChunkSize 并行转换时每个分块的默认大小
+const DefaultChunkSize = 4 << 20
+
+// WithParallelism 设置转换文件时使用的goroutine数量，n大于1时启用并行转换。
+// 并行转换将文件在字符边界处（尽量在换行符之后）切分为分块，分别转换后按顺序写入目标。
+// 仅对无状态的源字符集（UTF-8、UTF-16BE、UTF-16LE、GBK、GB18030、Big5、单字节字符集等）生效，
+// 源字符集为ISO-2022-JP、HZ-GB-2312等有状态字符集、设置了Handler或自定义解码器、编码器时仍按顺序转换
+func WithParallelism(n int) Option {
+	return func(c *Converter) {
+		c.parallelism = n
+	}
+}
+
+// WithChunkSize 设置并行转换时每个分块的大小，默认为DefaultChunkSize。文件不大于分块大小时不启用并行转换
+func WithChunkSize(size int) Option {
+	return func(c *Converter) {
+		if size > 0 {
+			c.chunkSize = size
+		}
+	}
+}
+
+// parallelizable 判断是否可以并行转换文件f
+func (c *Converter) parallelizable(f *os.File) bool {
+	if c.parallelism <= 1 || c.handler != nil || c.decoder != nil || c.encoder != nil {
+		return false
+	}
+	if c.newline == NewlineDetect {
+		// 各分块独立检测，无法发现跨分块的混用
+		return false
+	}
+	if c.offsets != nil {
+		// 偏移映射需要按顺序逐字符记录
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode().IsRegular() && fi.Size() > int64(c.chunkSize)
+}
+
+// splitFunc 返回p中最后一个可以安全切分的位置（0 < cut <= len(p)），找不到时返回-1。
+// 切分点总是位于字符边界上，且不会将\r\n拆开
+type splitFunc func(p []byte) int
+
+// splitterOf 返回srcCharset对应的splitFunc，不能安全切分的字符集返回nil
+func splitterOf(srcCharset string) splitFunc {
+	switch {
+	case isUTF8(srcCharset):
+		return splitUTF8
+	case charsetEquals(srcCharset, UTF16LE):
+		return splitUTF16(false)
+	case charsetEquals(srcCharset, UTF16BE):
+		return splitUTF16(true)
+	}
+	e := EncodingOf(srcCharset)
+	if !asciiCompatible(e) {
+		return nil
+	}
+	if _, ok := e.(*charmap.Charmap); ok {
+		return splitSingleByte
+	}
+	return splitDBCS
+}
+
+// splitAfterLF 返回p中最后一个\n之后的位置。兼容ASCII的字符集中0x0A不会出现在多字节字符内部
+func splitAfterLF(p []byte) int {
+	if i := bytes.LastIndexByte(p, '\n'); i >= 0 {
+		return i + 1
+	}
+	return -1
+}
+
+func splitUTF8(p []byte) int {
+	if cut := splitAfterLF(p); cut > 0 {
+		return cut
+	}
+	for i := len(p) - 1; i > 0; i-- {
+		if p[i]&0xC0 != 0x80 && p[i-1] != '\r' {
+			return i
+		}
+	}
+	return -1
+}
+
+func splitSingleByte(p []byte) int {
+	if cut := splitAfterLF(p); cut > 0 {
+		return cut
+	}
+	for i := len(p); i > 0; i-- {
+		if p[i-1] != '\r' {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitDBCS 多字节字符集的尾字节可能落在ASCII范围内，只有连续两个ASCII字节之间才能确定是字符边界
+func splitDBCS(p []byte) int {
+	if cut := splitAfterLF(p); cut > 0 {
+		return cut
+	}
+	for i := len(p) - 1; i > 0; i-- {
+		if p[i] < 0x80 && p[i-1] < 0x80 && p[i-1] != '\r' {
+			return i
+		}
+	}
+	return -1
+}
+
+func splitUTF16(bigEndian bool) splitFunc {
+	unit := func(p []byte, i int) uint16 {
+		if bigEndian {
+			return uint16(p[i])<<8 | uint16(p[i+1])
+		}
+		return uint16(p[i+1])<<8 | uint16(p[i])
+	}
+	return func(p []byte) int {
+		n := len(p) &^ 1
+		for i := n - 2; i >= 0; i -= 2 {
+			if unit(p, i) == '\n' {
+				return i + 2
+			}
+		}
+		for i := n - 2; i > 0; i -= 2 {
+			if u := unit(p, i); (u < 0xDC00 || u > 0xDFFF) && unit(p, i-2) != '\r' {
+				return i
+			}
+		}
+		return -1
+	}
+}
+
+// chunk 并行转换的一个分块
+type chunk struct {
+	index int
+	// base 分块在源数据中的偏移
+	base int64
+	data []byte
+
+	out      []byte
+	stats    Stats
+	endsLine bool
+	err      error
+	done     chan struct{}
+}
+
+// runParallel 将src按分块并行转换，并按顺序写入dest，返回未经包装的错误
+func (c *Converter) runParallel(src io.Reader, dest io.Writer, srcCharset string, split splitFunc, stats *Stats) error {
+	jobs := make(chan *chunk)
+	queue := make(chan *chunk, c.parallelism)
+	quit := make(chan struct{})
+
+	var readErr error
+	go func() {
+		defer close(queue)
+		defer close(jobs)
+		readErr = c.readChunks(src, split, func(ch *chunk) bool {
+			select {
+			case queue <- ch:
+			case <-quit:
+				return false
+			}
+			select {
+			case jobs <- ch:
+				return true
+			case <-quit:
+				close(ch.done)
+				return false
+			}
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// 除第一个分块外，其余分块不处理BOM
+			var st *state
+			for ch := range jobs {
+				if ch.index == 0 {
+					first := c.getState(srcCharset)
+					c.convertChunk(first, ch, stats != nil)
+					c.putState(first)
+				} else {
+					if st == nil {
+						st = c.newState(srcCharset)
+						st.bom = nil
+						if st.sanitize != nil {
+							st.sanitize.continued = true
+						}
+					}
+					c.convertChunk(st, ch, stats != nil)
+				}
+				close(ch.done)
+			}
+		}()
+	}
+
+	var err error
+	openLine := false
+	for ch := range queue {
+		if err != nil {
+			continue
+		}
+		<-ch.done
+		err = ch.err
+		if err == nil {
+			_, err = dest.Write(ch.out)
+		}
+		if err != nil {
+			close(quit)
+			continue
+		}
+		if stats != nil {
+			mergeChunkStats(stats, ch, openLine)
+			if ch.stats.Runes > 0 {
+				openLine = !ch.endsLine
+			}
+		}
+	}
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	return readErr
+}
+
+// readChunks 从src中读取数据，在安全的位置切分为分块并交给emit，emit返回false时停止读取
+func (c *Converter) readChunks(src io.Reader, split splitFunc, emit func(ch *chunk) bool) error {
+	var carry []byte
+	var base int64
+	for index := 0; ; index++ {
+		data := make([]byte, len(carry)+c.chunkSize)
+		copy(data, carry)
+		n, err := io.ReadFull(src, data[len(carry):])
+		data = data[:len(carry)+n]
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return err
+		}
+
+		cut := len(data)
+		if !eof {
+			cut = split(data)
+			if cut < 0 {
+				// 找不到安全的切分位置，与后续数据合并
+				carry = data
+				index--
+				continue
+			}
+		}
+		carry = data[cut:]
+		ch := &chunk{index: index, base: base, data: data[:cut], done: make(chan struct{})}
+		if !emit(ch) {
+			return nil
+		}
+		base += int64(cut)
+		if eof {
+			return nil
+		}
+	}
+}
+
+// convertChunk 使用st转换分块ch
+func (c *Converter) convertChunk(st *state, ch *chunk, withStats bool) {
+	var stats *Stats
+	if withStats {
+		stats = &ch.stats
+	}
+	t := st.begin(stats)
+	if t == nil {
+		ch.out = ch.data
+	} else {
+		// 大小限制由run包装的dest在写入时检查
+		ch.out, _, ch.err = appendTransform(make([]byte, 0, len(ch.data)+len(ch.data)/2), ch.data, t, -1)
+	}
+	if st.observer != nil && withStats {
+		ch.endsLine = st.observer.lastRune == '\n' || st.observer.lastRune == '\r'
+	}
+	st.detach()
+
+	// 错误中的偏移为分块内的偏移，需要换算为源数据中的偏移
+	switch e := ch.err.(type) {
+	case ErrInvalidByteSequence:
+		e.Offset += ch.base
+		ch.err = e
+	case ErrUnmappableRune:
+		e.Offset += ch.base
+		ch.err = e
+	}
+}
+
+// mergeChunkStats 将分块的统计信息合并到stats，openLine表示此前最后一行尚未结束
+func mergeChunkStats(stats *Stats, ch *chunk, openLine bool) {
+	s := &ch.stats
+	if ch.index == 0 {
+		stats.BOMFound = s.BOMFound
+		stats.BOMStripped = s.BOMStripped
+	}
+	if openLine && s.Runes > 0 {
+		// 上一个分块的最后一行与本分块的第一行是同一行，不能重复计数
+		stats.Lines--
+	}
+	stats.Runes += s.Runes
+	stats.Lines += s.Lines
+	stats.InvalidSequences += s.InvalidSequences
+	stats.Unmappable += s.Unmappable
+	stats.Sanitized += s.Sanitized
+	switch {
+	case stats.Newline == NewlineNone:
+		stats.Newline = s.Newline
+	case s.Newline != NewlineNone && s.Newline != stats.Newline:
+		stats.Newline = NewlineMixed
+	}
+}
@@ -0,0 +1,101 @@
+package charconv
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFile 将text以charset编码写入临时目录下的文件
+func writeTestFile(t *testing.T, text string, charset string) string {
+	data, err := EncodeStringToBytesWithCharset(text, 0, charset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "src.txt")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// convertFileBothWays 分别以顺序和并行方式转换文件，要求结果和统计信息一致
+func convertFileBothWays(t *testing.T, path string, opts ...Option) []byte {
+	sequential, err := NewConverter(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MakeByteBuffer(0)
+	wantStats, err := sequential.ConvertFile(path, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parallel, err := NewConverter(append(opts, WithParallelism(4), WithChunkSize(100))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := MakeByteBuffer(0)
+	gotStats, err := parallel.ConvertFile(path, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatalf("%q != %q", got.Bytes(), want.Bytes())
+	}
+	if gotStats != wantStats {
+		t.Fatal(gotStats, wantStats)
+	}
+	return got.Bytes()
+}
+
+func TestConvertFileParallel(t *testing.T) {
+	text := strings.Repeat("第1行 line one\r\n你好，世界 hello\r\n", 200) + "最后一行"
+	for _, charset := range []string{GBK, GB18030, Big5, UTF8, UTF16LE, UTF16BE} {
+		src := text
+		if charset == UTF8 || charset == UTF16LE || charset == UTF16BE {
+			src = "\uFEFF" + text
+		}
+		path := writeTestFile(t, src, charset)
+		dest := convertFileBothWays(t, path, WithSourceCharset(charset), WithBOMPolicy(BOMStrip), WithNewlinePolicy(NewlineToLF))
+		if string(dest) != strings.ReplaceAll(text, "\r\n", "\n") {
+			t.Fatal(charset, string(dest))
+		}
+	}
+}
+
+func TestConvertFileParallelNoNewline(t *testing.T) {
+	// 没有换行符时只能在连续的ASCII字节之间切分
+	text := strings.Repeat("你好世界ab", 300)
+	path := writeTestFile(t, text, GBK)
+	dest := convertFileBothWays(t, path, WithSourceCharset(GBK), WithTargetCharset(EUCJP))
+	if want, _ := EncodeStringToBytesWithCharset(text, 0, EUCJP); !bytes.Equal(dest, want) {
+		t.Fatal(string(dest))
+	}
+}
+
+func TestConvertFileParallelStateful(t *testing.T) {
+	text := strings.Repeat("こんにちは世界\n", 100)
+	path := writeTestFile(t, text, ISO2022JP)
+	dest := convertFileBothWays(t, path, WithSourceCharset(ISO2022JP))
+	if string(dest) != text {
+		t.Fatal(string(dest))
+	}
+}
+
+func TestConvertFileParallelError(t *testing.T) {
+	text := strings.Repeat("hello world\n", 50) + "你"
+	path := writeTestFile(t, text, UTF8)
+	c, err := NewConverter(WithTargetCharset(ISO88591), WithErrorPolicy(PolicyStrict), WithParallelism(4), WithChunkSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ConvertFile(path, MakeByteBuffer(0))
+	var target ErrUnmappableRune
+	if !errors.As(err, &target) || target.Offset != int64(len(text)-len("你")) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,455 @@
+package charconv
+
+import (
+	"io"
+	"os"
+	"slices"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Stage 流水线中的一个阶段，通过DecodeStage、EncodeStage、TransformStage等函数创建
+type Stage struct {
+	name string
+	// charset 编码阶段的目标字符集，用于确定之前的NormalizationAuto规范化阶段的行为
+	charset string
+	// autoNorm 为true时表示NormalizationAuto规范化阶段，创建Pipeline时根据之后的编码阶段确定
+	autoNorm bool
+	// build 为一次转换创建该阶段的Transformer，该阶段的统计信息记录在stats中
+	build func(stats *Stats) transform.Transformer
+	err   error
+}
+
+// Name 返回阶段的名称
+func (s Stage) Name() string {
+	return s.name
+}
+
+// DecodeStage 将charset编码的数据解码为UTF-8，opts可设置错误策略、事件回调、自动检测读取的字节数等Converter选项。
+// charset为AutoDetect时根据数据开头的内容检测编码，检测结果记录在该阶段统计信息的DetectedCharset中；
+// charset为UTF-8时按错误策略处理非法的UTF-8序列
+func DecodeStage(charset string, opts ...Option) Stage {
+	c, err := NewConverter(append([]Option{withOp(OpDecode), WithSourceCharset(charset)}, opts...)...)
+	s := Stage{name: "decode(" + charset + ")", err: err}
+	if err != nil {
+		return s
+	}
+	if c.decoder == nil && c.srcCharset == AutoDetect {
+		s.build = func(stats *Stats) transform.Transformer {
+			return &detectDecoder{c: c, stats: stats}
+		}
+		return s
+	}
+	s.build = func(stats *Stats) transform.Transformer {
+		return c.stageDecoder(c.srcCharset, stats)
+	}
+	return s
+}
+
+// stageDecoder 返回将charset解码为UTF-8的Transformer，非法字节序列按c的错误策略处理并记录在stats中
+func (c *Converter) stageDecoder(charset string, stats *Stats) transform.Transformer {
+	decoder, _ := c.codecs(charset)
+	if decoder == nil {
+		decoder = unicode.UTF8.NewDecoder()
+	}
+	h := newDecodeHandler(decoder, c.policy, c.handler)
+	h.stats = stats
+	return h
+}
+
+// EncodeStage 将UTF-8数据编码为charset，opts可设置错误策略、事件回调等Converter选项。
+// charset为UTF-8时按错误策略处理非法的UTF-8序列
+func EncodeStage(charset string, opts ...Option) Stage {
+	c, err := NewConverter(append([]Option{withOp(OpEncode), WithTargetCharset(charset)}, opts...)...)
+	s := Stage{name: "encode(" + charset + ")", err: err}
+	if err != nil {
+		return s
+	}
+	if c.encoder == nil {
+		s.charset = c.destCharset
+	}
+	s.build = func(stats *Stats) transform.Transformer {
+		_, encoder := c.codecs(c.srcCharset)
+		if encoder == nil {
+			encoder = transform.Nop
+		}
+		h := newEncodeHandler(encoder, c.policy, c.handler)
+		h.stats = stats
+		return h
+	}
+	return s
+}
+
+// BOMStage 按policy处理UTF-8数据开头的BOM（U+FEFF）。
+// BOMAdd在输出开头写入U+FEFF，之后的编码阶段应为UTF-8、UTF-16BE或UTF-16LE
+func BOMStage(policy BOMPolicy) Stage {
+	return Stage{
+		name: "bom",
+		build: func(stats *Stats) transform.Transformer {
+			if policy == BOMKeep {
+				return transform.Nop
+			}
+			return newBOMTransformer(policy == BOMAdd, stats)
+		},
+	}
+}
+
+// NormalizeStage 对UTF-8数据执行Unicode规范化。
+// NormalizationAuto根据之后第一个编码阶段的目标字符集确定，参见WithNormalization
+func NormalizeStage(form NormalizationForm) Stage {
+	return Stage{
+		name:     "normalize(" + form.String() + ")",
+		autoNorm: form == NormalizationAuto,
+		build:    normalizeBuilder(form, ""),
+	}
+}
+
+func normalizeBuilder(form NormalizationForm, destCharset string) func(stats *Stats) transform.Transformer {
+	return func(*Stats) transform.Transformer {
+		if t := newNormTransformer(form, destCharset); t != nil {
+			return t
+		}
+		return transform.Nop
+	}
+}
+
+// SanitizeStage 删除（replacement为空时）或替换UTF-8数据中属于classes的字符，参见WithSanitize。
+// 清理的字符数记录在该阶段统计信息的Sanitized中，需要为不同类别设置不同的处理方式时可以使用多个SanitizeStage
+func SanitizeStage(classes SanitizeClass, replacement string) Stage {
+	var rules sanitizeRules
+	rules.set(classes, replacement)
+	return Stage{
+		name: "sanitize",
+		build: func(stats *Stats) transform.Transformer {
+			return newSanitizer(&rules, stats)
+		},
+	}
+}
+
+// NewlineStage 按policy在Unicode域中处理UTF-8数据的换行符
+func NewlineStage(policy NewlinePolicy) Stage {
+	return Stage{
+		name: "newline",
+		build: func(*Stats) transform.Transformer {
+			if policy == NewlinePreserve {
+				return transform.Nop
+			}
+			return newNewlineTransformer(policy)
+		},
+	}
+}
+
+// TransformStage 将任意transform.Transformer作为名为name的阶段。
+// t在各次转换之间共享（每次转换开始时调用其Reset），包含此类阶段的Pipeline不能被多个goroutine同时使用，
+// 需要并发时使用TransformFuncStage
+func TransformStage(name string, t transform.Transformer) Stage {
+	return Stage{
+		name: name,
+		build: func(*Stats) transform.Transformer {
+			return t
+		},
+	}
+}
+
+// TransformFuncStage 将newTransformer创建的transform.Transformer作为名为name的阶段，每次转换创建新的Transformer
+func TransformFuncStage(name string, newTransformer func() transform.Transformer) Stage {
+	return Stage{
+		name: name,
+		build: func(*Stats) transform.Transformer {
+			return newTransformer()
+		},
+	}
+}
+
+// Pipeline 由若干阶段组成的转换流水线，各阶段通过transform.Chain串联，数据只经过一次读写。
+// Pipeline创建后不可修改，不包含TransformStage时可以被多个goroutine同时使用
+type Pipeline struct {
+	stages []Stage
+}
+
+// NewPipeline 依次串联stages创建Pipeline，某个阶段创建失败（如字符集不受支持）时返回其错误
+func NewPipeline(stages ...Stage) (*Pipeline, error) {
+	p := &Pipeline{stages: slices.Clone(stages)}
+	for i := range p.stages {
+		s := &p.stages[i]
+		if s.err != nil {
+			return nil, s.err
+		}
+		if s.autoNorm {
+			for _, next := range p.stages[i+1:] {
+				if next.charset != "" {
+					s.build = normalizeBuilder(NormalizationAuto, next.charset)
+					break
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+// Stages 返回各阶段的名称
+func (p *Pipeline) Stages() []string {
+	names := make([]string, len(p.stages))
+	for i, s := range p.stages {
+		names[i] = s.name
+	}
+	return names
+}
+
+// StageStats 流水线中一个阶段的统计信息。
+// BytesIn、BytesOut为该阶段读入和输出的字节数，其余字段只在适用于该阶段时记录，
+// 例如解码阶段的InvalidSequences、DetectedCharset，编码阶段的Unmappable，BOM阶段的BOMStripped，清理阶段的Sanitized
+type StageStats struct {
+	Name string
+	Stats
+}
+
+// PipelineStats 一次流水线转换的统计信息
+type PipelineStats struct {
+	// BytesIn 从源读取的字节数
+	BytesIn int64
+	// BytesOut 写入目标的字节数
+	BytesOut int64
+	// Stages 各阶段的统计信息，顺序与阶段的顺序一致
+	Stages []StageStats
+}
+
+// pipelineRun 一次流水线转换使用的Transformer及统计信息
+type pipelineRun struct {
+	chain transform.Transformer
+	stats PipelineStats
+}
+
+// begin 为一次转换创建各阶段的Transformer
+func (p *Pipeline) begin() *pipelineRun {
+	run := &pipelineRun{stats: PipelineStats{Stages: make([]StageStats, len(p.stages))}}
+	if len(p.stages) == 0 {
+		run.chain = transform.Nop
+		return run
+	}
+	ts := make([]transform.Transformer, len(p.stages))
+	for i, s := range p.stages {
+		st := &run.stats.Stages[i]
+		st.Name = s.name
+		var t transform.Transformer = transform.Nop
+		if s.build != nil {
+			t = s.build(&st.Stats)
+		}
+		ts[i] = &stageMeter{t: t, index: i, name: s.name, stats: &st.Stats}
+	}
+	run.chain = transform.Chain(ts...)
+	run.chain.Reset()
+	return run
+}
+
+// snapshot 返回统计信息的副本
+func (run *pipelineRun) snapshot(in, out int64) PipelineStats {
+	stats := run.stats
+	stats.BytesIn, stats.BytesOut = in, out
+	stats.Stages = slices.Clone(stats.Stages)
+	return stats
+}
+
+// stageMeter 统计一个阶段读入和输出的字节数，并将该阶段的错误包装为ErrStage
+type stageMeter struct {
+	t     transform.Transformer
+	index int
+	name  string
+	stats *Stats
+}
+
+func (m *stageMeter) Reset() {
+	m.t.Reset()
+}
+
+func (m *stageMeter) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	nDst, nSrc, err = m.t.Transform(dst, src, atEOF)
+	m.stats.BytesIn += int64(nSrc)
+	m.stats.BytesOut += int64(nDst)
+	switch {
+	case err == nil, err == transform.ErrShortDst:
+	case err == transform.ErrShortSrc:
+		if atEOF {
+			// 数据已经结束，剩余的数据是不完整的字符
+			err = stageFailed(m.index, m.name, truncatedStream(m.stats.BytesIn, false))
+		}
+	default:
+		err = stageFailed(m.index, m.name, err)
+	}
+	return nDst, nSrc, err
+}
+
+// Convert 从src读取数据，经过流水线转换后写入dest
+func (p *Pipeline) Convert(src io.Reader, dest io.Writer) (PipelineStats, error) {
+	run := p.begin()
+	cr := &countingReader{r: src}
+	cw := &countingWriter{w: dest}
+	err := pump(cw, cr, run.chain, make([]byte, defaultBufferSize), make([]byte, defaultBufferSize))
+	return run.snapshot(cr.n, cw.n), opError(OpConvert, "", err)
+}
+
+// ConvertBytes 转换src，返回转换结果
+func (p *Pipeline) ConvertBytes(src []byte) ([]byte, PipelineStats, error) {
+	run := p.begin()
+	dest, _, err := appendTransform(make([]byte, 0, len(src)+len(src)/2), src, run.chain, -1)
+	stats := run.snapshot(int64(len(src)), int64(len(dest)))
+	if err != nil {
+		return nil, stats, opError(OpConvert, "", err)
+	}
+	return dest, stats, nil
+}
+
+// ConvertString 转换字符串src，返回转换结果
+func (p *Pipeline) ConvertString(src string) (string, PipelineStats, error) {
+	dest, stats, err := p.ConvertBytes(unsafeBytes(src))
+	return unsafeString(dest), stats, err
+}
+
+// ConvertFile 转换源文件srcFilePath并写入dest
+func (p *Pipeline) ConvertFile(srcFilePath string, dest io.Writer) (PipelineStats, error) {
+	srcFile, err := os.Open(srcFilePath)
+	if err != nil {
+		return PipelineStats{}, opError(OpConvert, srcFilePath, err)
+	}
+	defer CloseQuietly(srcFile)
+	stats, err := p.Convert(srcFile, dest)
+	return stats, opError(OpConvert, srcFilePath, err)
+}
+
+// ConvertToFile 转换src并写入目标文件destFilePath，目标文件以destFileFlag打开。
+// 与Converter的默认行为相同，直接写入目标文件，参见WithAtomicWrite
+func (p *Pipeline) ConvertToFile(src io.Reader, destFilePath string, destFileFlag int) (PipelineStats, error) {
+	var stats PipelineStats
+	write := func(w io.Writer) (err error) {
+		stats, err = p.Convert(src, w)
+		return err
+	}
+	if srcFile, ok := src.(*os.File); ok && sameFile(srcFile, destFilePath) {
+		// 目标文件即源文件时，只能先写入临时文件
+		return stats, writeViaTmpFile(OpConvert, "", destFilePath, destFileFlag, write)
+	}
+	return stats, writeOutput(OpConvert, destFilePath, destFileFlag, false, write)
+}
+
+// ConvertFileToFile 转换源文件srcFilePath并写入目标文件destFilePath，目标文件以destFileFlag打开
+func (p *Pipeline) ConvertFileToFile(srcFilePath string, destFilePath string, destFileFlag int) (PipelineStats, error) {
+	srcFile, err := os.Open(srcFilePath)
+	if err != nil {
+		return PipelineStats{}, opError(OpConvert, srcFilePath, err)
+	}
+	defer CloseQuietly(srcFile)
+	stats, err := p.ConvertToFile(srcFile, destFilePath, destFileFlag)
+	return stats, opError(OpConvert, srcFilePath, err)
+}
+
+// PipelineReader 从底层Reader读取数据并经过流水线转换
+type PipelineReader struct {
+	r   *transform.Reader
+	cr  *countingReader
+	run *pipelineRun
+	n   int64
+}
+
+// NewReader 返回从r读取数据并经过流水线转换的PipelineReader
+func (p *Pipeline) NewReader(r io.Reader) *PipelineReader {
+	run := p.begin()
+	cr := &countingReader{r: r}
+	return &PipelineReader{r: transform.NewReader(cr, run.chain), cr: cr, run: run}
+}
+
+func (r *PipelineReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF {
+		err = opError(OpConvert, "", err)
+	}
+	return n, err
+}
+
+// Stats 返回到目前为止的统计信息
+func (r *PipelineReader) Stats() PipelineStats {
+	return r.run.snapshot(r.cr.n, r.n)
+}
+
+// PipelineWriter 将写入的数据经过流水线转换后写入底层Writer，写入完成后必须调用Close
+type PipelineWriter struct {
+	w   *transform.Writer
+	cw  *countingWriter
+	run *pipelineRun
+	n   int64
+}
+
+// NewWriter 返回将数据经过流水线转换后写入w的PipelineWriter
+func (p *Pipeline) NewWriter(w io.Writer) *PipelineWriter {
+	run := p.begin()
+	cw := &countingWriter{w: w}
+	return &PipelineWriter{w: transform.NewWriter(cw, run.chain), cw: cw, run: run}
+}
+
+func (w *PipelineWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, opError(OpConvert, "", err)
+}
+
+// Close 转换并写入剩余的数据，不会关闭底层Writer
+func (w *PipelineWriter) Close() error {
+	return opError(OpConvert, "", w.w.Close())
+}
+
+// Stats 返回到目前为止的统计信息
+func (w *PipelineWriter) Stats() PipelineStats {
+	return w.run.snapshot(w.n, w.cw.n)
+}
+
+// detectDecoder 缓存数据开头的若干字节，检测其编码后再创建相应的解码器
+type detectDecoder struct {
+	c     *Converter
+	stats *Stats
+	// head 用于检测的数据，off之前的部分已交给解码器
+	head []byte
+	off  int
+	dec  transform.Transformer
+}
+
+func (t *detectDecoder) Reset() {
+	t.head = t.head[:0]
+	t.off = 0
+	t.dec = nil
+}
+
+func (t *detectDecoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	if t.dec == nil {
+		nSrc = min(len(src), t.c.detectBytes-len(t.head))
+		t.head = append(t.head, src[:nSrc]...)
+		if len(t.head) < t.c.detectBytes && !atEOF {
+			return 0, nSrc, nil
+		}
+		charset, err := detectCharset(t.head)
+		if err != nil {
+			return 0, nSrc, err
+		}
+		t.stats.DetectedCharset = charset
+		t.dec = t.c.stageDecoder(charset, t.stats)
+	}
+
+	for t.off < len(t.head) {
+		n, m, err := t.dec.Transform(dst[nDst:], t.head[t.off:], atEOF && nSrc == len(src))
+		nDst += n
+		t.off += m
+		if err == transform.ErrShortSrc && nSrc < len(src) {
+			// head以不完整的字符结尾，从src中补充数据
+			k := min(len(src)-nSrc, utf8.UTFMax)
+			t.head = append(t.head, src[nSrc:nSrc+k]...)
+			nSrc += k
+			continue
+		}
+		if err != nil {
+			return nDst, nSrc, err
+		}
+	}
+	n, m, err := t.dec.Transform(dst[nDst:], src[nSrc:], atEOF)
+	return nDst + n, nSrc + m, err
+}
@@ -0,0 +1,228 @@
+package charconv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+)
+
+func TestPipelineConvert(t *testing.T) {
+	p, err := NewPipeline(
+		DecodeStage(GBK),
+		BOMStage(BOMAdd),
+		NormalizeStage(NormalizationNFC),
+		NewlineStage(NewlineToLF),
+		TransformStage("upper", runes.Map(unicode.ToUpper)),
+		EncodeStage(UTF16LE),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := p.Stages(); len(names) != 6 || names[0] != "decode(GBK)" || names[4] != "upper" {
+		t.Fatal(names)
+	}
+	src, err := EncodeStringToBytesWithCharset("abc\r\n中文\r\n", 0, GBK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := EncodeStringToBytesWithCharset("\ufeffABC\n中文\n", 0, UTF16LE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := MakeByteBuffer(0)
+	stats, err := p.Convert(iotest.OneByteReader(bytes.NewReader(src)), dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dest.Bytes(), want) {
+		t.Fatal(dest.Bytes())
+	}
+	if stats.BytesIn != int64(len(src)) || stats.BytesOut != int64(len(want)) || len(stats.Stages) != 6 {
+		t.Fatal(stats)
+	}
+	if stats.Stages[0].BytesIn != int64(len(src)) || stats.Stages[0].BytesOut != 13 {
+		t.Fatal(stats.Stages[0])
+	}
+	if stats.Stages[3].BytesIn != 16 || stats.Stages[3].BytesOut != 14 {
+		t.Fatal(stats.Stages[3])
+	}
+	if stats.Stages[5].Name != "encode(UTF-16LE)" || stats.Stages[5].BytesOut != int64(len(want)) {
+		t.Fatal(stats.Stages[5])
+	}
+
+	out, bstats, err := p.ConvertBytes(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, want) || bstats.Stages[0].BytesIn != int64(len(src)) {
+		t.Fatal(out, bstats)
+	}
+}
+
+func TestPipelineStageStats(t *testing.T) {
+	p, err := NewPipeline(
+		DecodeStage(GBK, WithErrorPolicy(PolicyReplace)),
+		EncodeStage(ISO88591, WithErrorPolicy(PolicyReplace)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, stats, err := p.ConvertBytes([]byte{'a', 0x81, 0x20, 0xD6, 0xD0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dest) != "a\x1a \x1a" {
+		t.Fatalf("%q", dest)
+	}
+	if stats.Stages[0].InvalidSequences != 1 || stats.Stages[1].Unmappable != 2 {
+		t.Fatal(stats)
+	}
+}
+
+func TestPipelineStageError(t *testing.T) {
+	p, err := NewPipeline(NewlineStage(NewlineToCRLF), EncodeStage(ISO88591))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = p.ConvertString("a\n中")
+	var stage ErrStage
+	if !errors.As(err, &stage) || stage.Index != 1 || stage.Name != "encode(ISO-8859-1)" {
+		t.Fatal(err)
+	}
+	var target ErrUnmappableRune
+	if !errors.Is(err, ErrUnmappable) || !errors.As(err, &target) || target.Offset != 3 {
+		t.Fatal(err)
+	}
+
+	p, err = NewPipeline(DecodeStage(UTF16LE, WithErrorPolicy(PolicyStrict)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = p.ConvertBytes([]byte{'a', 0, 'b'})
+	if !errors.Is(err, ErrInvalidSequence) || !errors.As(err, &stage) || stage.Index != 0 {
+		t.Fatal(err)
+	}
+
+	if _, err = NewPipeline(DecodeStage("no-such-charset")); !errors.Is(err, ErrUnsupported) {
+		t.Fatal(err)
+	}
+}
+
+func TestPipelineDetect(t *testing.T) {
+	p, err := NewPipeline(DecodeStage(AutoDetect, WithDetectBytes(16)), BOMStage(BOMStrip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := EncodeStringToBytesWithCharset("\ufeff中文abc中文abc", 0, UTF16LE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := MakeByteBuffer(0)
+	stats, err := p.Convert(iotest.OneByteReader(bytes.NewReader(src)), dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest.String() != "中文abc中文abc" {
+		t.Fatalf("%q", dest.String())
+	}
+	if stats.Stages[0].DetectedCharset != UTF16LE || !stats.Stages[1].BOMStripped {
+		t.Fatal(stats)
+	}
+}
+
+func TestPipelineAutoNormalization(t *testing.T) {
+	p, err := NewPipeline(NormalizeStage(NormalizationAuto), EncodeStage(ShiftJIS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, _, err := p.ConvertString("\u304b\u3099")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := EncodeStringToBytesWithCharset("\u304c", 0, ShiftJIS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest != string(want) {
+		t.Fatalf("%q", dest)
+	}
+}
+
+func TestPipelineReaderWriter(t *testing.T) {
+	p, err := NewPipeline(
+		DecodeStage(GBK),
+		TransformFuncStage("upper", func() transform.Transformer {
+			return runes.Map(unicode.ToUpper)
+		}),
+		EncodeStage(EUCJP),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := EncodeStringToBytesWithCharset("abc中文", 0, GBK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := EncodeStringToBytesWithCharset("ABC中文", 0, EUCJP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := p.NewReader(iotest.OneByteReader(bytes.NewReader(src)))
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, want) || r.Stats().BytesIn != int64(len(src)) || r.Stats().BytesOut != int64(len(want)) {
+		t.Fatal(out, r.Stats())
+	}
+
+	buffer := MakeByteBuffer(0)
+	w := p.NewWriter(buffer)
+	for i := range src {
+		if _, err = w.Write(src[i : i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffer.Bytes(), want) || w.Stats().BytesOut != int64(len(want)) {
+		t.Fatal(buffer.Bytes(), w.Stats())
+	}
+}
+
+func TestPipelineFileToFile(t *testing.T) {
+	p, err := NewPipeline(DecodeStage(GBK), NewlineStage(NewlineToLF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(t.TempDir(), "out.txt")
+	stats, err := p.ConvertFileToFile("./test/test_gbk.txt", dest, CreateOrTrunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	destBytes, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(destBytes, utf8Data) || stats.BytesOut != int64(len(utf8Data)) {
+		t.Fatal(stats)
+	}
+
+	_, err = p.ConvertFile("./test/no_such_file.txt", io.Discard)
+	var opErr *OpError
+	if !errors.As(err, &opErr) || !strings.HasSuffix(opErr.Path, "no_such_file.txt") {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package charconv
+
+// raceEnabled 是否启用了竞态检测器。竞态检测器会额外分配内存，内存分配相关的断言需要跳过
+const raceEnabled = true
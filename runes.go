@@ -0,0 +1,572 @@
+package charconv
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+// runeKind RuneCodec的实现方式
+type runeKind int
+
+const (
+	kindUTF8 runeKind = iota
+	kindUTF16LE
+	kindUTF16BE
+	// kindCharmap 单字节字符集，直接使用charmap.Charmap的码表
+	kindCharmap
+	// kindTable 无状态的多字节字符集，首次使用时通过解码器建立一、二字节序列的码表
+	kindTable
+	// kindStateful 有状态或需要BOM的字符集，每次调用都从初始状态开始使用解码器、编码器
+	kindStateful
+)
+
+// 码表项中的特殊值，其余值为解码得到的字符
+const (
+	// entryInvalid1 非法序列，只消耗一个字节
+	entryInvalid1 = -1 - iota
+	// entryInvalid2 非法序列，消耗两个字节
+	entryInvalid2
+	// entryLead 多字节序列的首字节
+	entryLead
+	// entryLong 超过两个字节的序列（GB18030四字节序列、EUC-JP三字节序列），交由解码器处理
+	entryLong
+	// entryMulti 解码得到多个字符的二字节序列（例如Big5的88 62解码为U+00CA U+0304），解码结果记录在runeTable.multi中
+	entryMulti
+)
+
+// runeTable 无状态多字节字符集的码表
+type runeTable struct {
+	single [256]int32
+	trails [256]*[256]int32
+	// encode 字符到一、二字节编码的映射，高16位为长度
+	encode map[rune]uint32
+	// multi entryMulti序列（首字节<<8|尾字节）解码得到的UTF-8文本
+	multi map[uint16]string
+}
+
+// RuneCodec 以字符为单位对某个字符集进行编解码，类似unicode/utf8，可被多个goroutine同时使用
+type RuneCodec struct {
+	charset string
+	e       encoding.Encoding
+	kind    runeKind
+	cm      *charmap.Charmap
+
+	once  sync.Once
+	table *runeTable
+
+	// decoders、encoders 缓存慢速路径使用的解码器和编码器
+	decoders sync.Pool
+	encoders sync.Pool
+	// scratch 缓存慢速路径传给解码器、编码器的缓冲区，这些缓冲区会逃逸到堆上
+	scratch sync.Pool
+}
+
+// runeCodecs 缓存各字符集名称对应的RuneCodec
+var runeCodecs sync.Map
+
+// RuneCodecOf 返回charset对应的RuneCodec，字符集不受支持时返回错误
+func RuneCodecOf(charset string) (*RuneCodec, error) {
+	if v, ok := runeCodecs.Load(charset); ok {
+		return v.(*RuneCodec), nil
+	}
+	e := EncodingOf(charset)
+	if e == nil {
+		return nil, unsupported(charset)
+	}
+	rc := &RuneCodec{charset: charset, e: e}
+	switch {
+	case isUTF8(charset):
+		rc.kind = kindUTF8
+	case charsetEquals(charset, UTF16LE):
+		rc.kind = kindUTF16LE
+	case charsetEquals(charset, UTF16BE):
+		rc.kind = kindUTF16BE
+	default:
+		if cm, ok := e.(*charmap.Charmap); ok {
+			rc.kind = kindCharmap
+			rc.cm = cm
+		} else if asciiCompatible(e) {
+			rc.kind = kindTable
+		} else {
+			rc.kind = kindStateful
+		}
+	}
+	v, _ := runeCodecs.LoadOrStore(charset, rc)
+	return v.(*RuneCodec), nil
+}
+
+// decoder 从池中取出已重置的解码器，使用完毕后通过putDecoder放回
+func (rc *RuneCodec) decoder() *encoding.Decoder {
+	if d, ok := rc.decoders.Get().(*encoding.Decoder); ok {
+		d.Reset()
+		return d
+	}
+	return rc.e.NewDecoder()
+}
+
+func (rc *RuneCodec) putDecoder(d *encoding.Decoder) {
+	rc.decoders.Put(d)
+}
+
+// encoder 从池中取出已重置的编码器，使用完毕后通过putEncoder放回
+func (rc *RuneCodec) encoder() *encoding.Encoder {
+	if e, ok := rc.encoders.Get().(*encoding.Encoder); ok {
+		e.Reset()
+		return e
+	}
+	return rc.e.NewEncoder()
+}
+
+func (rc *RuneCodec) putEncoder(e *encoding.Encoder) {
+	rc.encoders.Put(e)
+}
+
+// buffer 从池中取出慢速路径使用的缓冲区，使用完毕后通过putBuffer放回
+func (rc *RuneCodec) buffer() *[32]byte {
+	if b, ok := rc.scratch.Get().(*[32]byte); ok {
+		return b
+	}
+	return new([32]byte)
+}
+
+func (rc *RuneCodec) putBuffer(b *[32]byte) {
+	rc.scratch.Put(b)
+}
+
+// Charset 返回字符集名称
+func (rc *RuneCodec) Charset() string {
+	return rc.charset
+}
+
+// DecodeRune 解码p开头的第一个字符，返回该字符及其长度。
+// p为空时返回(utf8.RuneError, 0)；非法或不完整的序列返回utf8.RuneError及其长度（至少为1）。
+// 解码得到多个字符的合法序列（例如Big5的88 62解码为U+00CA U+0304）无法以一个字符表示，同样返回utf8.RuneError及其长度，
+// 但Valid认为其合法。有状态的字符集从初始状态开始解码，长度包含字符之前的转义序列
+func (rc *RuneCodec) DecodeRune(p []byte) (rune, int) {
+	r, size, _ := rc.decodeRune(p)
+	return r, size
+}
+
+// decodeRune 与DecodeRune相同，同时报告序列是否合法，以区分合法编码的U+FFFD及解码得到多个字符的序列
+func (rc *RuneCodec) decodeRune(p []byte) (r rune, size int, ok bool) {
+	if len(p) == 0 {
+		return utf8.RuneError, 0, false
+	}
+	switch rc.kind {
+	case kindUTF8:
+		r, size = utf8.DecodeRune(p)
+		return r, size, r != utf8.RuneError || size > 1
+	case kindUTF16LE, kindUTF16BE:
+		return rc.decodeUTF16(p)
+	case kindCharmap:
+		r = rc.cm.DecodeByte(p[0])
+		return r, 1, r != utf8.RuneError
+	case kindTable:
+		t := rc.codeTable()
+		switch e := t.single[p[0]]; e {
+		case entryInvalid1:
+			return utf8.RuneError, 1, false
+		case entryLead:
+			if len(p) < 2 {
+				return utf8.RuneError, 1, false
+			}
+			switch e := t.trails[p[0]][p[1]]; e {
+			case entryInvalid1:
+				return utf8.RuneError, 1, false
+			case entryInvalid2:
+				return utf8.RuneError, 2, false
+			case entryMulti:
+				return utf8.RuneError, 2, true
+			case entryLong:
+			default:
+				return rune(e), 2, true
+			}
+		default:
+			return rune(e), 1, true
+		}
+	}
+	return rc.decodeSlow(p)
+}
+
+func (rc *RuneCodec) decodeUTF16(p []byte) (rune, int, bool) {
+	if len(p) < 2 {
+		return utf8.RuneError, len(p), false
+	}
+	u := rc.uint16(p)
+	switch {
+	case !utf16.IsSurrogate(rune(u)):
+		return rune(u), 2, true
+	case u >= 0xDC00 || len(p) < 4:
+		return utf8.RuneError, 2, false
+	}
+	r := utf16.DecodeRune(rune(u), rune(rc.uint16(p[2:])))
+	if r == utf8.RuneError {
+		return r, 2, false
+	}
+	return r, 4, true
+}
+
+func (rc *RuneCodec) uint16(p []byte) uint16 {
+	if rc.kind == kindUTF16BE {
+		return binary.BigEndian.Uint16(p)
+	}
+	return binary.LittleEndian.Uint16(p)
+}
+
+// decodeSlow 通过解码器解码第一个字符：逐步放大输出窗口，使解码器恰好产生一个字符
+func (rc *RuneCodec) decodeSlow(p []byte) (rune, int, bool) {
+	d := rc.decoder()
+	defer rc.putDecoder(d)
+	buf := rc.buffer()
+	defer rc.putBuffer(buf)
+	for w := 1; w <= len(buf); w++ {
+		d.Reset()
+		nDst, nSrc, err := d.Transform(buf[:w], p, false)
+		if nDst > 0 {
+			r, size := utf8.DecodeRune(buf[:nDst])
+			if r == utf8.RuneError && !isLegitFFFD(p[:nSrc]) {
+				return r, nSrc, false
+			}
+			if size < nDst {
+				// 解码得到多个字符
+				return utf8.RuneError, nSrc, true
+			}
+			return r, nSrc, true
+		}
+		if err == nil {
+			// 只有转义序列，没有字符
+			return utf8.RuneError, max(nSrc, 1), false
+		}
+		if err != transform.ErrShortDst {
+			break
+		}
+	}
+	return utf8.RuneError, 1, false
+}
+
+// decodedSize 返回decodeRune报告为合法、但解码结果不是一个字符的序列seq（合法编码的U+FFFD或解码得到多个字符的序列）解码后的UTF-8长度
+func (rc *RuneCodec) decodedSize(seq []byte) int {
+	if rc.kind == kindTable && len(seq) == 2 {
+		if s, ok := rc.codeTable().multi[uint16(seq[0])<<8|uint16(seq[1])]; ok {
+			return len(s)
+		}
+	}
+	d := rc.decoder()
+	defer rc.putDecoder(d)
+	buf := rc.buffer()
+	defer rc.putBuffer(buf)
+	nDst, _, _ := d.Transform(buf[:], seq, true)
+	return nDst
+}
+
+// FullRune 判断p是否以一个完整的字符（或非法序列）开头
+func (rc *RuneCodec) FullRune(p []byte) bool {
+	if len(p) == 0 {
+		return false
+	}
+	switch rc.kind {
+	case kindUTF8:
+		return utf8.FullRune(p)
+	case kindUTF16LE, kindUTF16BE:
+		if len(p) < 2 {
+			return false
+		}
+		u := rc.uint16(p)
+		return len(p) >= 4 || u < 0xD800 || u >= 0xDC00
+	case kindCharmap:
+		return true
+	case kindTable:
+		t := rc.codeTable()
+		if t.single[p[0]] != entryLead {
+			return true
+		}
+		if len(p) < 2 {
+			return false
+		}
+		if t.trails[p[0]][p[1]] != entryLong {
+			return true
+		}
+	}
+	var buf [64]byte
+	d := rc.decoder()
+	nDst, _, err := d.Transform(buf[:], p, false)
+	rc.putDecoder(d)
+	return nDst > 0 || err != transform.ErrShortSrc
+}
+
+// EncodeRune 将r编码后写入dst，返回写入的字节数。
+// r无法映射时返回ErrUnmappableRune，dst空间不足时返回io.ErrShortBuffer。
+// 有状态的字符集返回从初始状态开始、并恢复到初始状态的完整编码
+func (rc *RuneCodec) EncodeRune(dst []byte, r rune) (int, error) {
+	var buf [16]byte
+	n, ok := rc.encodeRune(buf[:], r)
+	if !ok {
+		return 0, opError(OpEncode, "", unmappableRune(0, r))
+	}
+	if len(dst) < n {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(dst, buf[:n]), nil
+}
+
+// AppendRune 将r编码后追加到dst末尾
+func (rc *RuneCodec) AppendRune(dst []byte, r rune) ([]byte, error) {
+	var buf [16]byte
+	n, ok := rc.encodeRune(buf[:], r)
+	if !ok {
+		return dst, opError(OpEncode, "", unmappableRune(0, r))
+	}
+	return append(dst, buf[:n]...), nil
+}
+
+// RuneLen 返回r编码后的字节数，无法映射时返回-1
+func (rc *RuneCodec) RuneLen(r rune) int {
+	var buf [16]byte
+	n, ok := rc.encodeRune(buf[:], r)
+	if !ok {
+		return -1
+	}
+	return n
+}
+
+// encodeRune 将r编码到buf（至少16字节）中
+func (rc *RuneCodec) encodeRune(buf []byte, r rune) (int, bool) {
+	switch rc.kind {
+	case kindUTF8:
+		if !utf8.ValidRune(r) {
+			return 0, false
+		}
+		return utf8.EncodeRune(buf, r), true
+	case kindUTF16LE, kindUTF16BE:
+		if !utf8.ValidRune(r) {
+			return 0, false
+		}
+		if r < 0x10000 {
+			rc.putUint16(buf, uint16(r))
+			return 2, true
+		}
+		r1, r2 := utf16.EncodeRune(r)
+		rc.putUint16(buf, uint16(r1))
+		rc.putUint16(buf[2:], uint16(r2))
+		return 4, true
+	case kindCharmap:
+		b, ok := rc.cm.EncodeRune(r)
+		buf[0] = b
+		return 1, ok
+	case kindTable:
+		if r < utf8.RuneSelf {
+			buf[0] = byte(r)
+			return 1, true
+		}
+		if v, ok := rc.codeTable().encode[r]; ok {
+			n := int(v >> 16)
+			buf[0], buf[1] = byte(v>>8), byte(v)
+			if n == 1 {
+				buf[0] = byte(v)
+			}
+			return n, true
+		}
+	}
+	return rc.encodeSlow(buf, r)
+}
+
+func (rc *RuneCodec) putUint16(p []byte, v uint16) {
+	if rc.kind == kindUTF16BE {
+		binary.BigEndian.PutUint16(p, v)
+	} else {
+		binary.LittleEndian.PutUint16(p, v)
+	}
+}
+
+// encodeSlow 通过编码器编码r。传给编码器的缓冲区会逃逸到堆上，因此不直接使用buf，而是使用池中的缓冲区，以免调用者分配内存
+func (rc *RuneCodec) encodeSlow(buf []byte, r rune) (int, bool) {
+	if !utf8.ValidRune(r) {
+		return 0, false
+	}
+	b := rc.buffer()
+	defer rc.putBuffer(b)
+	p := b[:utf8.UTFMax+16]
+	n := utf8.EncodeRune(p, r)
+	e := rc.encoder()
+	nDst, _, err := e.Transform(p[utf8.UTFMax:], p[:n], true)
+	rc.putEncoder(e)
+	return copy(buf, p[utf8.UTFMax:utf8.UTFMax+nDst]), err == nil
+}
+
+// Valid 判断p是否全部由合法的字符组成，不合法时同时返回第一个非法（或不完整）序列的偏移，合法时偏移为len(p)
+func (rc *RuneCodec) Valid(p []byte) (bool, int) {
+	switch rc.kind {
+	case kindUTF8:
+		if utf8.Valid(p) {
+			return true, len(p)
+		}
+		i := invalidUTF8Index(p)
+		return false, i
+	case kindStateful:
+		h := newDecodeHandler(rc.e.NewDecoder(), PolicyStrict, nil)
+		var buf [defaultBufferSize]byte
+		for off := 0; ; {
+			nDst, nSrc, err := h.Transform(buf[:], p[off:], true)
+			off += nSrc
+			var invalid ErrInvalidByteSequence
+			switch {
+			case err == nil:
+				return true, len(p)
+			case errors.As(err, &invalid):
+				return false, int(invalid.Offset)
+			case err != transform.ErrShortDst || nDst == 0 && nSrc == 0:
+				return false, off
+			}
+		}
+	}
+
+	for i := 0; i < len(p); {
+		if p[i] < utf8.RuneSelf && rc.kind == kindTable {
+			i++
+			continue
+		}
+		_, size, ok := rc.decodeRune(p[i:])
+		if !ok {
+			return false, i
+		}
+		i += size
+	}
+	return true, len(p)
+}
+
+// codeTable 返回码表，首次调用时建立
+func (rc *RuneCodec) codeTable() *runeTable {
+	rc.once.Do(func() {
+		rc.table = buildRuneTable(rc.e)
+	})
+	return rc.table
+}
+
+// buildRuneTable 逐个解码一、二字节序列建立码表，并通过编码器确定每个字符的规范编码
+func buildRuneTable(e encoding.Encoding) *runeTable {
+	t := &runeTable{encode: make(map[rune]uint32), multi: make(map[uint16]string)}
+	d := e.NewDecoder()
+	enc := e.NewEncoder()
+	var dst [16]byte
+	var src [utf8.UTFMax]byte
+
+	// decode 解码seq，返回码表项
+	decode := func(seq []byte) int32 {
+		d.Reset()
+		nDst, nSrc, err := d.Transform(dst[:], seq, false)
+		switch {
+		case err == transform.ErrShortSrc && nSrc == 0:
+			return entryLead
+		case nSrc == 1 && len(seq) == 2:
+			return entryInvalid1
+		}
+		r, size := utf8.DecodeRune(dst[:nDst])
+		if r == utf8.RuneError && !isLegitFFFD(seq[:nSrc]) {
+			if nSrc == 1 {
+				return entryInvalid1
+			}
+			return entryInvalid2
+		}
+		if size < nDst && len(seq) == 2 {
+			t.multi[uint16(seq[0])<<8|uint16(seq[1])] = string(dst[:nDst])
+			return entryMulti
+		}
+		return r
+	}
+	// addEncoding 记录r的规范编码
+	addEncoding := func(r rune) {
+		if r < utf8.RuneSelf {
+			return
+		}
+		if _, ok := t.encode[r]; ok {
+			return
+		}
+		enc.Reset()
+		n, _, err := enc.Transform(dst[:], src[:utf8.EncodeRune(src[:], r)], true)
+		switch {
+		case err != nil:
+		case n == 1:
+			t.encode[r] = 1<<16 | uint32(dst[0])
+		case n == 2:
+			t.encode[r] = 2<<16 | uint32(dst[0])<<8 | uint32(dst[1])
+		}
+	}
+
+	for b := 0; b < 256; b++ {
+		t.single[b] = decode([]byte{byte(b)})
+		if t.single[b] != entryLead {
+			if t.single[b] >= 0 {
+				addEncoding(t.single[b])
+			}
+			continue
+		}
+		trails := new([256]int32)
+		for c := 0; c < 256; c++ {
+			e := decode([]byte{byte(b), byte(c)})
+			if e == entryLead {
+				e = entryLong
+			}
+			trails[c] = e
+			if e >= 0 {
+				addEncoding(e)
+			}
+		}
+		t.trails[b] = trails
+	}
+	return t
+}
+
+// DecodeRune 解码charset编码的p开头的第一个字符，返回该字符及其长度，参见RuneCodec.DecodeRune。
+// 字符集不受支持时返回(utf8.RuneError, 0)
+func DecodeRune(charset string, p []byte) (rune, int) {
+	rc, err := RuneCodecOf(charset)
+	if err != nil {
+		return utf8.RuneError, 0
+	}
+	return rc.DecodeRune(p)
+}
+
+// EncodeRune 将r以charset编码后写入dst，返回写入的字节数，参见RuneCodec.EncodeRune
+func EncodeRune(charset string, dst []byte, r rune) (int, error) {
+	rc, err := RuneCodecOf(charset)
+	if err != nil {
+		return 0, opError(OpEncode, "", err)
+	}
+	return rc.EncodeRune(dst, r)
+}
+
+// RuneLen 返回r以charset编码后的字节数，无法映射或字符集不受支持时返回-1
+func RuneLen(charset string, r rune) int {
+	rc, err := RuneCodecOf(charset)
+	if err != nil {
+		return -1
+	}
+	return rc.RuneLen(r)
+}
+
+// FullRune 判断charset编码的p是否以一个完整的字符开头，字符集不受支持时返回false
+func FullRune(charset string, p []byte) bool {
+	rc, err := RuneCodecOf(charset)
+	if err != nil {
+		return false
+	}
+	return rc.FullRune(p)
+}
+
+// Valid 判断p是否为合法的charset编码数据，并返回第一个非法序列的偏移，参见RuneCodec.Valid。
+// 字符集不受支持时返回(false, 0)
+func Valid(charset string, p []byte) (bool, int) {
+	rc, err := RuneCodecOf(charset)
+	if err != nil {
+		return false, 0
+	}
+	return rc.Valid(p)
+}
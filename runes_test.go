@@ -0,0 +1,138 @@
+package charconv
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDecodeRune(t *testing.T) {
+	cases := []struct {
+		charset string
+		text    string
+	}{
+		{GBK, "a你好€"},
+		{GB18030, "a你好\U0001F600ÿ"},
+		{Big5, "a中文"},
+		{ShiftJIS, "aソ表ｱ"},
+		{EUCJP, "a日本ｱ"},
+		{EUCKR, "a한국"},
+		{ISO88591, "aé"},
+		{IBM037, "ab"},
+		{UTF8, "a你\U0001F600"},
+		{UTF16LE, "a你\U0001F600"},
+		{UTF16BE, "a你\U0001F600"},
+		{ISO2022JP, "a日本"},
+	}
+	for _, c := range cases {
+		rc, err := RuneCodecOf(c.charset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var encoded []byte
+		for _, r := range c.text {
+			n := RuneLen(c.charset, r)
+			if encoded, err = rc.AppendRune(encoded, r); err != nil {
+				t.Fatal(c.charset, string(r), err)
+			}
+			p := encoded[len(encoded)-n:]
+			if got, size := DecodeRune(c.charset, p); got != r || size != n {
+				t.Fatal(c.charset, string(r), got, size, n)
+			}
+			if !FullRune(c.charset, p) || FullRune(c.charset, p[:n-1]) && n > 1 && c.charset != ISO2022JP {
+				t.Fatal(c.charset, string(r), p)
+			}
+		}
+		if ok, off := Valid(c.charset, encoded); !ok || off != len(encoded) {
+			t.Fatal(c.charset, off)
+		}
+	}
+}
+
+func TestDecodeRuneInvalid(t *testing.T) {
+	cases := []struct {
+		charset string
+		p       []byte
+		size    int
+		offset  int
+	}{
+		{GBK, []byte{0xFF, 'a'}, 1, 0},
+		{GBK, []byte{0xC4}, 1, 0},
+		{ShiftJIS, []byte{0x81, 0x20}, 2, 0},
+		{UTF8, []byte{0xE4, 0xBD}, 1, 0},
+		{UTF16LE, []byte{0x00, 0xD8, 'a', 0}, 2, 0},
+		{GB18030, []byte{0x81, 0x30, 0x81}, 1, 0},
+	}
+	for _, c := range cases {
+		if r, size := DecodeRune(c.charset, c.p); r != utf8.RuneError || size != c.size {
+			t.Fatal(c.charset, c.p, r, size)
+		}
+		if ok, off := Valid(c.charset, append([]byte("ab"), c.p...)); ok || off != c.offset+2 {
+			t.Fatal(c.charset, c.p, off)
+		}
+	}
+
+	// 合法编码的U+FFFD
+	if ok, _ := Valid(GB18030, []byte{0x84, 0x31, 0xA4, 0x37}); !ok {
+		t.Fatal()
+	}
+	if ok, off := Valid(ISO2022JP, []byte("ab\x1b$B\x30")); ok || off != 5 {
+		t.Fatal(off)
+	}
+	if r, size := DecodeRune(GBK, nil); r != utf8.RuneError || size != 0 {
+		t.Fatal(r, size)
+	}
+	if FullRune(GB18030, []byte{0x81, 0x30}) || !FullRune(GB18030, []byte{0x81, 0x30, 0x81, 0x30}) {
+		t.Fatal()
+	}
+}
+
+func TestDecodeRuneMulti(t *testing.T) {
+	// Big5的88 62解码为U+00CA U+0304，无法以一个字符表示
+	p := []byte{0x88, 0x62, 'a'}
+	if r, size := DecodeRune(Big5, p); r != utf8.RuneError || size != 2 {
+		t.Fatal(r, size)
+	}
+	if ok, off := Valid(Big5, p); !ok || off != len(p) {
+		t.Fatal(off)
+	}
+	if r, size := DecodeRune(Big5, p[2:]); r != 'a' || size != 1 {
+		t.Fatal(r, size)
+	}
+}
+
+func TestEncodeRune(t *testing.T) {
+	var buf [8]byte
+	if n, err := EncodeRune(GBK, buf[:], '你'); err != nil || string(buf[:n]) != "\xc4\xe3" {
+		t.Fatal(buf[:n], err)
+	}
+	if n, err := EncodeRune(ISO2022JP, buf[:], '日'); err != nil || string(buf[:n]) != "\x1b$BF|\x1b(B" {
+		t.Fatalf("%q %v", buf[:n], err)
+	}
+	if _, err := EncodeRune(ISO88591, buf[:], '你'); !errors.Is(err, ErrUnmappable) {
+		t.Fatal(err)
+	}
+	if _, err := EncodeRune(GBK, buf[:1], '你'); err != io.ErrShortBuffer {
+		t.Fatal(err)
+	}
+	if _, err := EncodeRune("no-such-charset", buf[:], 'a'); !errors.Is(err, ErrUnsupported) {
+		t.Fatal(err)
+	}
+	if RuneLen(GBK, '\U0001F600') != -1 || RuneLen(GB18030, '\U0001F600') != 4 || RuneLen(UTF16BE, '\U0001F600') != 4 {
+		t.Fatal()
+	}
+}
+
+func BenchmarkDecodeRuneGBK(b *testing.B) {
+	data, _ := EncodeStringToBytesWithCharset("你好，世界！hello 中文编码", 0, GBK)
+	rc, _ := RuneCodecOf(GBK)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for p := data; len(p) > 0; {
+			_, size := rc.DecodeRune(p)
+			p = p[size:]
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package charconv
+
+import (
+	"math/bits"
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
+)
+
+// SanitizeClass 可清理的字符类别，可以按位组合
+type SanitizeClass int
+
+const (
+	// SanitizeNUL U+0000
+	SanitizeNUL SanitizeClass = 1 << iota
+	// SanitizeSUB U+001A，DOS及部分旧系统将其用作文件结束标记
+	SanitizeSUB
+	// SanitizeC1 C1控制字符U+0080-U+009F
+	SanitizeC1
+	// SanitizeBOM 不在数据开头的U+FEFF，数据开头的BOM由BOM策略处理
+	SanitizeBOM
+	// SanitizeZeroWidth 零宽字符U+200B、U+200C、U+200D、U+2060。注意U+200D也用于组合emoji序列
+	SanitizeZeroWidth
+	// SanitizeXMLInvalid XML 1.0中不允许出现的字符：除\t、\n、\r以外的C0控制字符，以及U+FFFE、U+FFFF
+	SanitizeXMLInvalid
+
+	// SanitizeAll 以上所有类别
+	SanitizeAll = SanitizeNUL | SanitizeSUB | SanitizeC1 | SanitizeBOM | SanitizeZeroWidth | SanitizeXMLInvalid
+)
+
+// numSanitizeClasses 字符类别的数量
+const numSanitizeClasses = 6
+
+// WithSanitize 在Unicode域中清理属于classes的字符：replacement为空时删除，否则替换为replacement，
+// 清理的字符数记录在Stats.Sanitized中。可多次使用，为不同的类别设置不同的处理方式，同一类别以最后一次设置为准
+func WithSanitize(classes SanitizeClass, replacement string) Option {
+	return func(c *Converter) {
+		c.sanitize.set(classes, replacement)
+	}
+}
+
+// sanitizeRules 各类别字符的处理方式
+type sanitizeRules struct {
+	classes SanitizeClass
+	// replacement 各类别的替换文本，为空时删除
+	replacement [numSanitizeClasses]string
+}
+
+func (r *sanitizeRules) set(classes SanitizeClass, replacement string) {
+	classes &= SanitizeAll
+	for i := 0; i < numSanitizeClasses; i++ {
+		if classes&(1<<i) != 0 {
+			r.replacement[i] = replacement
+		}
+	}
+	r.classes |= classes
+}
+
+// match 返回字符c所属的、需要清理的类别的序号，不需要清理时返回-1。leading表示c位于数据开头
+func (r *sanitizeRules) match(c rune, leading bool) int {
+	var class SanitizeClass
+	switch {
+	case c == 0:
+		class = SanitizeNUL
+	case c == 0x1A:
+		class = SanitizeSUB
+	case 0x80 <= c && c <= 0x9F:
+		class = SanitizeC1
+	case c == 0xFEFF:
+		if leading {
+			return -1
+		}
+		class = SanitizeBOM
+	case c == 0x200B, c == 0x200C, c == 0x200D, c == 0x2060:
+		class = SanitizeZeroWidth
+	}
+	if r.classes&class == 0 {
+		class = 0
+		if r.classes&SanitizeXMLInvalid != 0 && xmlInvalid(c) {
+			class = SanitizeXMLInvalid
+		}
+	}
+	if class == 0 {
+		return -1
+	}
+	return bits.TrailingZeros(uint(class))
+}
+
+// xmlInvalid 判断c是否为XML 1.0中不允许出现的字符。合法的UTF-8中不会出现代理项
+func xmlInvalid(c rune) bool {
+	return c < 0x20 && c != '\t' && c != '\n' && c != '\r' || c == 0xFFFE || c == 0xFFFF
+}
+
+// sanitizer 位于Unicode域（UTF-8）的Transformer，按sanitizeRules删除或替换字符，非法的UTF-8序列原样保留
+type sanitizer struct {
+	rules *sanitizeRules
+	stats *Stats
+	// continued 数据不是从开头开始（并行转换中除第一个以外的分块），开头的U+FEFF同样需要清理
+	continued bool
+	started   bool
+}
+
+func newSanitizer(rules *sanitizeRules, stats *Stats) *sanitizer {
+	return &sanitizer{rules: rules, stats: stats}
+}
+
+func (s *sanitizer) Reset() {
+	s.started = false
+}
+
+func (s *sanitizer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		// 可见的ASCII字符不需要清理
+		end := nSrc
+		for end < len(src) && src[end] >= 0x20 && src[end] < utf8.RuneSelf {
+			end++
+		}
+		if end > nSrc {
+			n := copy(dst[nDst:], src[nSrc:end])
+			nDst += n
+			nSrc += n
+			s.started = true
+			if nSrc < end {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			continue
+		}
+
+		c, size := rune(src[nSrc]), 1
+		if c >= utf8.RuneSelf {
+			if !atEOF && !utf8.FullRune(src[nSrc:]) {
+				return nDst, nSrc, transform.ErrShortSrc
+			}
+			c, size = utf8.DecodeRune(src[nSrc:])
+		}
+		out := src[nSrc : nSrc+size]
+		i := s.rules.match(c, !s.started && !s.continued)
+		if i >= 0 {
+			out = unsafeBytes(s.rules.replacement[i])
+		}
+		if nDst+len(out) > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += copy(dst[nDst:], out)
+		nSrc += size
+		s.started = true
+		if i >= 0 && s.stats != nil {
+			s.stats.Sanitized++
+		}
+	}
+	return nDst, nSrc, nil
+}
@@ -0,0 +1,106 @@
+package charconv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSanitizeRemove(t *testing.T) {
+	c, err := NewConverter(WithSourceCharset(GBK), WithSanitize(SanitizeAll, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := []byte("a\x00b\x1ac\x01\t\r\n")
+	src = append(src, 0xD6, 0xD0, 0x1A)
+	dest := MakeByteBuffer(0)
+	stats, err := c.Convert(iotest.OneByteReader(bytes.NewReader(src)), dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest.String() != "abc\t\r\n\u4e2d" || stats.Sanitized != 4 {
+		t.Fatalf("%q %v", dest.String(), stats)
+	}
+}
+
+func TestSanitizeClasses(t *testing.T) {
+	src := "\ufeffa\u0085b\ufeffc\u200bd\u200de\ufffff\x00g\x7f"
+	cases := []struct {
+		opts []Option
+		want string
+		n    int64
+	}{
+		{[]Option{WithSanitize(SanitizeC1, "")}, "\ufeffab\ufeffc\u200bd\u200de\ufffff\x00g\x7f", 1},
+		{[]Option{WithSanitize(SanitizeBOM, "")}, "\ufeffa\u0085bc\u200bd\u200de\ufffff\x00g\x7f", 1},
+		{[]Option{WithSanitize(SanitizeZeroWidth, "")}, "\ufeffa\u0085b\ufeffcde\ufffff\x00g\x7f", 2},
+		{[]Option{WithSanitize(SanitizeXMLInvalid, "?")}, "\ufeffa\u0085b\ufeffc\u200bd\u200de?f?g\x7f", 2},
+		{
+			[]Option{WithSanitize(SanitizeAll, ""), WithSanitize(SanitizeNUL, " ")},
+			"\ufeffabcdef g\x7f", 6,
+		},
+		{
+			[]Option{WithSanitize(SanitizeAll, ""), WithBOMPolicy(BOMStrip)},
+			"abcdefg\x7f", 6,
+		},
+	}
+	for i, tc := range cases {
+		c, err := NewConverter(tc.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dest, stats, err := c.ConvertString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dest != tc.want || stats.Sanitized != tc.n {
+			t.Fatalf("case %d: %q %d", i, dest, stats.Sanitized)
+		}
+	}
+}
+
+func TestSanitizeParallel(t *testing.T) {
+	line := "\ufeffx\u200by\n"
+	src := strings.Repeat(line, 4096)
+	path := filepath.Join(t.TempDir(), "src.txt")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewConverter(WithSanitize(SanitizeBOM|SanitizeZeroWidth, ""), WithParallelism(4), WithChunkSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := MakeByteBuffer(0)
+	stats, err := c.ConvertFile(path, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\ufeff" + strings.Repeat("xy\n", 4096)
+	if dest.String() != want || stats.Sanitized != 2*4096-1 {
+		t.Fatal(len(dest.String()), stats.Sanitized)
+	}
+}
+
+func TestSanitizeStage(t *testing.T) {
+	p, err := NewPipeline(
+		DecodeStage(UTF16LE),
+		SanitizeStage(SanitizeNUL, ""),
+		SanitizeStage(SanitizeC1|SanitizeSUB, "?"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := EncodeStringToBytesWithCharset("a\x00b\u0080c\x1a", 0, UTF16LE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, stats, err := p.ConvertBytes(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dest) != "ab?c?" || stats.Stages[1].Sanitized != 1 || stats.Stages[2].Sanitized != 2 {
+		t.Fatalf("%q %v", dest, stats)
+	}
+}
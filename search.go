@@ -0,0 +1,345 @@
+package charconv
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/transform"
+)
+
+// nextBoundary 返回s中从字符边界i开始的下一个字符边界
+func (rc *RuneCodec) nextBoundary(s []byte, i int) int {
+	if rc.kind == kindCharmap {
+		return i + 1
+	}
+	_, size, _ := rc.decodeRune(s[i:])
+	return i + max(size, 1)
+}
+
+// indexFrom 从pos开始查找sep，只接受位于字符边界的匹配。*boundary为不大于pos的字符边界，查找过程中向后推进
+func (rc *RuneCodec) indexFrom(s, sep []byte, pos int, boundary *int) int {
+	for pos <= len(s) {
+		i := bytes.Index(s[pos:], sep)
+		if i < 0 {
+			return -1
+		}
+		c := pos + i
+		for *boundary < c {
+			*boundary = rc.nextBoundary(s, *boundary)
+		}
+		if *boundary == c {
+			return c
+		}
+		// 边界之前的位置均位于字符中间
+		pos = *boundary
+	}
+	return -1
+}
+
+// boundaries 返回s中所有字符边界（不包括0和len(s)）
+func (rc *RuneCodec) boundaries(s []byte) []int {
+	var bs []int
+	for i := 0; i < len(s); {
+		i = rc.nextBoundary(s, i)
+		if i < len(s) {
+			bs = append(bs, i)
+		}
+	}
+	return bs
+}
+
+// Index 返回以本字符集编码的sep在s中第一次出现的位置，只匹配字符边界，不存在时返回-1。
+// 有状态的字符集先解码再查找
+func (rc *RuneCodec) Index(s, sep []byte) int {
+	if rc.kind == kindStateful {
+		return rc.indexDecoded(s, sep)
+	}
+	if len(sep) == 0 {
+		return 0
+	}
+	boundary := 0
+	return rc.indexFrom(s, sep, 0, &boundary)
+}
+
+// Contains 判断s中是否包含sep，只匹配字符边界
+func (rc *RuneCodec) Contains(s, sep []byte) bool {
+	return rc.Index(s, sep) >= 0
+}
+
+// Count 返回s中不重叠的sep的个数，只匹配字符边界。sep为空时返回字符数加1
+func (rc *RuneCodec) Count(s, sep []byte) int {
+	if rc.kind == kindStateful {
+		text, sepText, ok := rc.decodePair(s, sep)
+		if !ok {
+			return 0
+		}
+		return strings.Count(text, sepText)
+	}
+	if len(sep) == 0 {
+		return len(rc.boundaries(s)) + 1 + min(len(s), 1)
+	}
+	count := 0
+	boundary := 0
+	for pos := 0; ; count++ {
+		i := rc.indexFrom(s, sep, pos, &boundary)
+		if i < 0 {
+			return count
+		}
+		pos = i + len(sep)
+		boundary = pos
+	}
+}
+
+// Replace 返回将s中前n个不重叠的old替换为new后的副本，只匹配字符边界，n小于0时替换全部。
+// old为空时在每个字符边界（包括开头和结尾）插入new。
+// 有状态的字符集先解码、替换后再重新编码，s中的非法字节序列在结果中被替换为替代字符
+func (rc *RuneCodec) Replace(s, old, new []byte, n int) []byte {
+	if rc.kind == kindStateful {
+		text, oldText, ok := rc.decodePair(s, old)
+		if !ok {
+			return append([]byte(nil), s...)
+		}
+		newText, _ := DecodeBytesToBytesWithCharset(new, 0, rc.charset)
+		return rc.encodeReplacing(strings.Replace(text, oldText, string(newText), n))
+	}
+
+	var out []byte
+	last := 0
+	if len(old) == 0 {
+		for i, b := range append([]int{0}, append(rc.boundaries(s), len(s))...) {
+			if n >= 0 && i >= n || i > 0 && b == 0 {
+				break
+			}
+			out = append(append(out, s[last:b]...), new...)
+			last = b
+		}
+		return append(out, s[last:]...)
+	}
+
+	boundary := 0
+	for k := 0; n < 0 || k < n; k++ {
+		i := rc.indexFrom(s, old, last, &boundary)
+		if i < 0 {
+			break
+		}
+		out = append(append(out, s[last:i]...), new...)
+		last = i + len(old)
+		boundary = last
+	}
+	return append(out, s[last:]...)
+}
+
+// ReplaceAll 返回将s中所有不重叠的old替换为new后的副本，只匹配字符边界
+func (rc *RuneCodec) ReplaceAll(s, old, new []byte) []byte {
+	return rc.Replace(s, old, new, -1)
+}
+
+// Split 以sep切分s，只在字符边界处切分，结果与s共享内存。sep为空时切分为单个字符。
+// 有状态的字符集先解码、切分后再将各部分单独编码，结果不与s共享内存
+func (rc *RuneCodec) Split(s, sep []byte) [][]byte {
+	if rc.kind == kindStateful {
+		text, sepText, ok := rc.decodePair(s, sep)
+		if !ok {
+			return [][]byte{append([]byte(nil), s...)}
+		}
+		parts := strings.Split(text, sepText)
+		result := make([][]byte, len(parts))
+		for i, part := range parts {
+			result[i] = rc.encodeReplacing(part)
+		}
+		return result
+	}
+
+	var parts [][]byte
+	last := 0
+	if len(sep) == 0 {
+		for _, b := range rc.boundaries(s) {
+			parts = append(parts, s[last:b:b])
+			last = b
+		}
+		if len(s) > 0 {
+			parts = append(parts, s[last:])
+		}
+		return parts
+	}
+
+	boundary := 0
+	for {
+		i := rc.indexFrom(s, sep, last, &boundary)
+		if i < 0 {
+			break
+		}
+		parts = append(parts, s[last:i:i])
+		last = i + len(sep)
+		boundary = last
+	}
+	return append(parts, s[last:])
+}
+
+// indexDecoded 解码后在UTF-8文本中查找sep，并将结果换算为s中的偏移
+func (rc *RuneCodec) indexDecoded(s, sep []byte) int {
+	text, m, err := DecodeBytesWithOffsets(s, rc.charset)
+	if err != nil {
+		return -1
+	}
+	sepText, err := DecodeBytesToBytesWithCharset(sep, 0, rc.charset)
+	if err != nil {
+		return -1
+	}
+	i := bytes.Index(text, sepText)
+	if i < 0 {
+		return -1
+	}
+	return int(m.EncodedOffset(int64(i)))
+}
+
+// decodePair 解码s和sep
+func (rc *RuneCodec) decodePair(s, sep []byte) (string, string, bool) {
+	text, err := DecodeBytesToBytesWithCharset(s, 0, rc.charset)
+	if err != nil {
+		return "", "", false
+	}
+	sepText, err := DecodeBytesToBytesWithCharset(sep, 0, rc.charset)
+	if err != nil {
+		return "", "", false
+	}
+	return string(text), string(sepText), true
+}
+
+// encodeReplacing 编码text，无法映射的字符替换为替代字符
+func (rc *RuneCodec) encodeReplacing(text string) []byte {
+	e := rc.encoder()
+	defer rc.putEncoder(e)
+	out, _, _ := transform.Bytes(newEncodeHandler(e, PolicyReplace, nil), unsafeBytes(text))
+	return out
+}
+
+// IndexWithCharset 返回charset编码的sep在s中第一次出现的位置，只匹配字符边界，参见RuneCodec.Index。
+// 不存在或字符集不受支持时返回-1
+func IndexWithCharset(s, sep []byte, charset string) int {
+	rc, err := RuneCodecOf(charset)
+	if err != nil {
+		return -1
+	}
+	return rc.Index(s, sep)
+}
+
+// ContainsWithCharset 判断charset编码的s中是否包含sep，只匹配字符边界
+func ContainsWithCharset(s, sep []byte, charset string) bool {
+	return IndexWithCharset(s, sep, charset) >= 0
+}
+
+// ReplaceWithCharset 返回将charset编码的s中前n个old替换为new后的副本，参见RuneCodec.Replace。
+// 字符集不受支持时返回s的副本
+func ReplaceWithCharset(s, old, new []byte, n int, charset string) []byte {
+	rc, err := RuneCodecOf(charset)
+	if err != nil {
+		return append([]byte(nil), s...)
+	}
+	return rc.Replace(s, old, new, n)
+}
+
+// SplitWithCharset 以sep切分charset编码的s，只在字符边界处切分，参见RuneCodec.Split。
+// 字符集不受支持时返回只包含s的切片
+func SplitWithCharset(s, sep []byte, charset string) [][]byte {
+	rc, err := RuneCodecOf(charset)
+	if err != nil {
+		return [][]byte{s}
+	}
+	return rc.Split(s, sep)
+}
+
+// Regexp 在以某个字符集编码的数据上执行UTF-8正则表达式，匹配位置以原始编码中的字节偏移表示
+type Regexp struct {
+	re      *regexp.Regexp
+	charset string
+}
+
+// NewRegexp 创建在charset编码的数据上执行re的Regexp
+func NewRegexp(re *regexp.Regexp, charset string) (*Regexp, error) {
+	if _, err := RuneCodecOf(charset); err != nil {
+		return nil, err
+	}
+	return &Regexp{re: re, charset: charset}, nil
+}
+
+// CompileRegexp 编译正则表达式expr并创建在charset编码的数据上执行的Regexp
+func CompileRegexp(expr string, charset string) (*Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return NewRegexp(re, charset)
+}
+
+// Regexp 返回底层的正则表达式
+func (r *Regexp) Regexp() *regexp.Regexp {
+	return r.re
+}
+
+// decode 解码b，返回UTF-8文本及偏移映射
+func (r *Regexp) decode(b []byte) ([]byte, *OffsetMap) {
+	text, m, err := DecodeBytesWithOffsets(b, r.charset)
+	if err != nil {
+		// 字符集已在创建时检查过，默认策略下解码不会失败
+		return nil, &OffsetMap{}
+	}
+	return text, m
+}
+
+// encodedIndex 将UTF-8文本中的匹配位置换算为原始编码中的偏移，-1（未参与匹配的分组）保持不变
+func encodedIndex(m *OffsetMap, loc []int) []int {
+	for i, off := range loc {
+		if off >= 0 {
+			loc[i] = int(m.EncodedOffset(int64(off)))
+		}
+	}
+	return loc
+}
+
+// Match 判断b中是否存在匹配
+func (r *Regexp) Match(b []byte) bool {
+	text, _ := r.decode(b)
+	return r.re.Match(text)
+}
+
+// FindIndex 返回b中第一个匹配在原始编码中的位置[start, end)，不存在时返回nil
+func (r *Regexp) FindIndex(b []byte) []int {
+	text, m := r.decode(b)
+	loc := r.re.FindIndex(text)
+	if loc == nil {
+		return nil
+	}
+	return encodedIndex(m, loc)
+}
+
+// FindAllIndex 返回b中最多n个匹配在原始编码中的位置，n小于0时返回全部
+func (r *Regexp) FindAllIndex(b []byte, n int) [][]int {
+	text, m := r.decode(b)
+	locs := r.re.FindAllIndex(text, n)
+	for _, loc := range locs {
+		encodedIndex(m, loc)
+	}
+	return locs
+}
+
+// FindSubmatchIndex 返回b中第一个匹配及各分组在原始编码中的位置，不存在时返回nil
+func (r *Regexp) FindSubmatchIndex(b []byte) []int {
+	text, m := r.decode(b)
+	loc := r.re.FindSubmatchIndex(text)
+	if loc == nil {
+		return nil
+	}
+	return encodedIndex(m, loc)
+}
+
+// FindAllSubmatchIndex 返回b中最多n个匹配及各分组在原始编码中的位置，n小于0时返回全部
+func (r *Regexp) FindAllSubmatchIndex(b []byte, n int) [][]int {
+	text, m := r.decode(b)
+	locs := r.re.FindAllSubmatchIndex(text, n)
+	for _, loc := range locs {
+		encodedIndex(m, loc)
+	}
+	return locs
+}
@@ -0,0 +1,110 @@
+package charconv
+
+import (
+	"fmt"
+	"testing"
+)
+
+func encodeSJIS(t *testing.T, s string) []byte {
+	data, err := EncodeStringToBytesWithCharset(s, 0, ShiftJIS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestIndexWithCharset(t *testing.T) {
+	// "表"和"ソ"的第二个字节是0x5C
+	s := encodeSJIS(t, "表ソa\\b")
+	if i := IndexWithCharset(s, []byte("\\"), ShiftJIS); i != 5 {
+		t.Fatal(i)
+	}
+	if ContainsWithCharset(encodeSJIS(t, "表ソ"), []byte("\\"), ShiftJIS) {
+		t.Fatal()
+	}
+
+	// GBK中"你好"为C4 E3 BA C3，E3 BA不能在奇数偏移处匹配
+	gbk := []byte{0xC4, 0xE3, 0xBA, 0xC3, 0xE3, 0xBA}
+	if i := IndexWithCharset(gbk, []byte{0xE3, 0xBA}, GBK); i != 4 {
+		t.Fatal(i)
+	}
+
+	if i := IndexWithCharset([]byte{'a', 0, 'b', 0}, []byte{0, 'b'}, UTF16LE); i != -1 {
+		t.Fatal(i)
+	}
+	if i := IndexWithCharset([]byte{'a', 0, 'b', 0}, []byte{'b', 0}, UTF16LE); i != 2 {
+		t.Fatal(i)
+	}
+
+	// 有状态字符集按解码结果查找
+	jis, _ := EncodeStringToBytesWithCharset("abc日本語", 0, ISO2022JP)
+	sep, _ := EncodeStringToBytesWithCharset("本", 0, ISO2022JP)
+	if i := IndexWithCharset(jis, sep, ISO2022JP); i != 8 {
+		t.Fatal(i)
+	}
+	if i := IndexWithCharset(jis, []byte("F|"), ISO2022JP); i != -1 {
+		t.Fatal(i)
+	}
+}
+
+func TestReplaceSplitWithCharset(t *testing.T) {
+	rc, err := RuneCodecOf(ShiftJIS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := encodeSJIS(t, "表\\ソ\\x")
+	got := rc.ReplaceAll(s, []byte("\\"), []byte("/"))
+	if want := encodeSJIS(t, "表/ソ/x"); string(got) != string(want) {
+		t.Fatalf("%q", got)
+	}
+	if got := rc.Replace(s, []byte("\\"), []byte("/"), 1); string(got) != string(encodeSJIS(t, "表/ソ\\x")) {
+		t.Fatalf("%q", got)
+	}
+	if got := rc.Replace(encodeSJIS(t, "表a"), nil, []byte("-"), -1); string(got) != string(encodeSJIS(t, "-表-a-")) {
+		t.Fatalf("%q", got)
+	}
+
+	parts := rc.Split(s, []byte("\\"))
+	if fmt.Sprintf("%q", parts) != fmt.Sprintf("%q", [][]byte{encodeSJIS(t, "表"), encodeSJIS(t, "ソ"), []byte("x")}) {
+		t.Fatalf("%q", parts)
+	}
+	if parts := rc.Split(encodeSJIS(t, "表a"), nil); len(parts) != 2 || rc.Count(encodeSJIS(t, "表a"), nil) != 3 {
+		t.Fatalf("%q", parts)
+	}
+	if n := rc.Count(s, []byte("\\")); n != 2 {
+		t.Fatal(n)
+	}
+
+	jis, _ := EncodeStringToBytesWithCharset("日本,語", 0, ISO2022JP)
+	parts = SplitWithCharset(jis, []byte(","), ISO2022JP)
+	if len(parts) != 2 {
+		t.Fatalf("%q", parts)
+	}
+	if text, _ := DecodeBytesToBytesWithCharset(parts[1], 0, ISO2022JP); string(text) != "語" {
+		t.Fatalf("%q", parts)
+	}
+	replaced := ReplaceWithCharset(jis, []byte(","), []byte(";"), -1, ISO2022JP)
+	if text, _ := DecodeBytesToBytesWithCharset(replaced, 0, ISO2022JP); string(text) != "日本;語" {
+		t.Fatalf("%q", replaced)
+	}
+}
+
+func TestRegexp(t *testing.T) {
+	re, err := CompileRegexp(`ソ(\d+)`, ShiftJIS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := encodeSJIS(t, "表ソ12 ソ3")
+	if loc := re.FindSubmatchIndex(s); fmt.Sprint(loc) != "[2 6 4 6]" {
+		t.Fatal(loc)
+	}
+	if locs := re.FindAllIndex(s, -1); fmt.Sprint(locs) != "[[2 6] [7 10]]" {
+		t.Fatal(locs)
+	}
+	if !re.Match(s) || re.FindIndex([]byte("abc")) != nil {
+		t.Fatal()
+	}
+	if _, err := CompileRegexp(`a`, "no-such-charset"); err == nil {
+		t.Fatal()
+	}
+}
@@ -0,0 +1,308 @@
+package charconv
+
+import (
+	"io"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// defaultBufferSize 转换流时读写缓冲区的默认大小
+const defaultBufferSize = 4096
+
+// state 一次转换所需的Transformer及缓冲区。
+// 同一时刻只被一个goroutine使用，通过Converter内部的sync.Pool复用
+type state struct {
+	srcCharset string
+	pooled     bool
+
+	decoder transform.Transformer
+	encoder transform.Transformer
+	dh      *decodeHandler
+	eh      *encodeHandler
+	track   *offsetTrack
+	mapping *OffsetMap
+
+	observer *statsObserver
+	bom      *bomTransformer
+	sanitize *sanitizer
+	norm     *normTransformer
+	newline  *newlineTransformer
+
+	// chains[0]不收集统计信息，chains[1]收集统计信息，为nil表示无需转换
+	chains [2]transform.Transformer
+	built  [2]bool
+
+	srcBuf []byte
+	dstBuf []byte
+}
+
+// getState 从池中取出（或新建）用于转换srcCharset的state
+func (c *Converter) getState(srcCharset string) *state {
+	if srcCharset == c.srcCharset {
+		if st, ok := c.pool.Get().(*state); ok {
+			return st
+		}
+		st := c.newState(srcCharset)
+		st.pooled = true
+		return st
+	}
+	// 自动检测得到的字符集各不相同，不做缓存
+	return c.newState(srcCharset)
+}
+
+// putState 解除state与本次转换的关联并放回池中
+func (c *Converter) putState(st *state) {
+	st.detach()
+	if st.pooled {
+		c.pool.Put(st)
+	}
+}
+
+func (c *Converter) newState(srcCharset string) *state {
+	st := &state{srcCharset: srcCharset}
+	decoder, encoder := c.codecs(srcCharset)
+
+	if c.policy != PolicyDefault || c.handler != nil {
+		if decoder != nil && decodeHandlerOf(decoder) == nil {
+			decoder = newDecodeHandler(decoder, c.policy, c.handler)
+		}
+		if encoder != nil && encodeHandlerOf(encoder) == nil {
+			encoder = newEncodeHandler(encoder, c.policy, c.handler)
+		}
+		if decoder == nil && encoder == nil {
+			// 源与目标均为UTF-8时，通过UTF-8解码器检查非法序列
+			decoder = newDecodeHandler(unicode.UTF8.NewDecoder(), c.policy, c.handler)
+		}
+	}
+	if c.offsets != nil {
+		// 偏移映射由decodeHandler逐字符记录，源字符集为UTF-8时同样需要
+		if decoder == nil {
+			decoder = newDecodeHandler(unicode.UTF8.NewDecoder(), c.policy, c.handler)
+		} else if decodeHandlerOf(decoder) == nil {
+			decoder = newDecodeHandler(decoder, c.policy, c.handler)
+		}
+		st.mapping = c.offsets
+	}
+
+	st.eh = encodeHandlerOf(encoder)
+	if decoder != nil && (st.eh != nil || c.newline == NewlineDetect) {
+		// 同时存在解码器时，需要逐字符驱动解码器以便将无法映射字符、混用的换行符的偏移换算为源数据中的偏移
+		if decodeHandlerOf(decoder) == nil {
+			decoder = newDecodeHandler(decoder, PolicyDefault, nil)
+		}
+		st.track = &offsetTrack{}
+	}
+	st.dh = decodeHandlerOf(decoder)
+	st.decoder = decoder
+	st.encoder = encoder
+
+	if c.bom != BOMKeep {
+		st.bom = newBOMTransformer(c.bom == BOMAdd && writesBOM(c.destCharset), nil)
+	}
+	if c.sanitize.classes != 0 {
+		st.sanitize = newSanitizer(&c.sanitize, nil)
+	}
+	st.norm = c.normalizer()
+	if c.newline != NewlinePreserve {
+		st.newline = newNewlineTransformer(c.newline)
+		st.newline.prune = st.eh == nil
+	}
+	return st
+}
+
+// attach 将state与本次转换的stats（可为nil）关联
+func (st *state) attach(stats *Stats) {
+	if st.dh != nil {
+		st.dh.stats = stats
+	}
+	if st.eh != nil {
+		st.eh.stats = stats
+	}
+	if st.bom != nil {
+		st.bom.stats = stats
+	}
+	if st.sanitize != nil {
+		st.sanitize.stats = stats
+	}
+	if st.observer != nil {
+		st.observer.stats = stats
+	}
+	if st.track != nil {
+		// 解码器和编码器可能由调用者传入，仅在转换期间关联
+		st.dh.track = st.track
+		if st.eh != nil {
+			st.eh.origin = st.track
+		}
+		if st.newline != nil {
+			st.newline.origin = st.track
+		}
+	}
+}
+
+// detach 解除state与本次转换的关联
+func (st *state) detach() {
+	st.attach(nil)
+	if st.track != nil {
+		st.dh.track = nil
+		if st.eh != nil {
+			st.eh.origin = nil
+		}
+		if st.newline != nil {
+			st.newline.origin = nil
+		}
+	}
+	if st.mapping != nil {
+		if st.dh.mapping != nil {
+			st.mapping.release()
+			st.dh.mapping = nil
+		}
+		st.dh.mappingErr = nil
+	}
+}
+
+// begin 将state与本次转换关联，返回已重置的Transformer，无需转换时返回nil
+func (st *state) begin(stats *Stats) transform.Transformer {
+	i := 0
+	if stats != nil {
+		i = 1
+	}
+	if !st.built[i] {
+		st.chains[i] = st.chain(stats != nil)
+		st.built[i] = true
+	}
+	st.attach(stats)
+	if st.mapping != nil && st.dh.mapping == nil {
+		// 偏移映射在整个转换期间（Reader、Writer直到关闭）被占用，Reset时继续使用
+		st.dh.mappingErr = nil
+		if st.mapping.acquire() {
+			st.dh.mapping = st.mapping
+		} else {
+			st.dh.mappingErr = ErrOffsetMapInUse
+		}
+	}
+	t := st.chains[i]
+	if t != nil {
+		t.Reset()
+	}
+	return t
+}
+
+func (st *state) chain(withStats bool) transform.Transformer {
+	var transformers []transform.Transformer
+	if st.decoder != nil {
+		transformers = append(transformers, st.decoder)
+	}
+	if withStats {
+		if st.observer == nil {
+			st.observer = newStatsObserver(nil, st.decoder != nil && st.dh == nil)
+		}
+		transformers = append(transformers, st.observer)
+	}
+	if st.bom != nil {
+		transformers = append(transformers, st.bom)
+	}
+	if st.sanitize != nil {
+		transformers = append(transformers, st.sanitize)
+	}
+	if st.norm != nil {
+		transformers = append(transformers, st.norm)
+	}
+	if st.newline != nil {
+		transformers = append(transformers, st.newline)
+	}
+	if st.encoder != nil {
+		transformers = append(transformers, st.encoder)
+	}
+
+	switch len(transformers) {
+	case 0:
+		return nil
+	case 1:
+		return transformers[0]
+	}
+	return transform.Chain(transformers...)
+}
+
+// buffers 返回流式转换使用的读写缓冲区，大小至少为size
+func (st *state) buffers(size int) (srcBuf, dstBuf []byte) {
+	if size < defaultBufferSize {
+		size = defaultBufferSize
+	}
+	if len(st.srcBuf) < size {
+		st.srcBuf = make([]byte, size)
+		st.dstBuf = make([]byte, size)
+	}
+	return st.srcBuf, st.dstBuf
+}
+
+// pump 使用t将src转换后写入dest，逻辑与transform.Reader类似，但使用调用者提供的缓冲区
+func pump(dest io.Writer, src io.Reader, t transform.Transformer, srcBuf, dstBuf []byte) error {
+	if t == nil {
+		_, err := io.CopyBuffer(dest, src, srcBuf)
+		return err
+	}
+
+	src0, src1 := 0, 0
+	eof := false
+	for {
+		if src0 < src1 || eof {
+			nDst, nSrc, err := t.Transform(dstBuf, srcBuf[src0:src1], eof)
+			src0 += nSrc
+			if nDst > 0 {
+				if _, werr := dest.Write(dstBuf[:nDst]); werr != nil {
+					return werr
+				}
+			}
+			switch err {
+			case nil:
+				if eof {
+					return nil
+				}
+			case transform.ErrShortDst:
+				if nDst > 0 || nSrc > 0 {
+					continue
+				}
+				return err
+			case transform.ErrShortSrc:
+				if eof || src1-src0 == len(srcBuf) {
+					return err
+				}
+			default:
+				return err
+			}
+		}
+
+		// 将未处理的数据移到缓冲区开头并继续读取
+		if src0 > 0 {
+			src1 = copy(srcBuf, srcBuf[src0:src1])
+			src0 = 0
+		}
+		n, err := src.Read(srcBuf[src1:])
+		src1 += n
+		if err == io.EOF {
+			eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// pumpBytes 使用t将内存中的src转换后写入dest，返回写入的字节数
+func pumpBytes(dest io.Writer, src []byte, t transform.Transformer, dstBuf []byte) (int64, error) {
+	var written int64
+	for {
+		nDst, nSrc, err := t.Transform(dstBuf, src, true)
+		src = src[nSrc:]
+		if nDst > 0 {
+			n, werr := dest.Write(dstBuf[:nDst])
+			written += int64(n)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err != transform.ErrShortDst || nDst == 0 && nSrc == 0 {
+			return written, err
+		}
+	}
+}
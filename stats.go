@@ -0,0 +1,265 @@
+package charconv
+
+import (
+	"bytes"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// NewlineStyle 换行符风格
+type NewlineStyle int
+
+const (
+	// NewlineNone 未检测到换行符
+	NewlineNone NewlineStyle = iota
+	// NewlineLF Unix风格换行符（\n）
+	NewlineLF
+	// NewlineCRLF Windows风格换行符（\r\n）
+	NewlineCRLF
+	// NewlineCR 经典Mac风格换行符（\r）
+	NewlineCR
+	// NewlineMixed 同时存在多种换行符
+	NewlineMixed
+)
+
+func (s NewlineStyle) String() string {
+	switch s {
+	case NewlineNone:
+		return "none"
+	case NewlineLF:
+		return "LF"
+	case NewlineCRLF:
+		return "CRLF"
+	case NewlineCR:
+		return "CR"
+	case NewlineMixed:
+		return "mixed"
+	}
+	return "unknown"
+}
+
+// Stats 一次转换过程的统计信息
+type Stats struct {
+	// BytesIn 从源读取的字节数
+	BytesIn int64
+	// BytesOut 写入目标的字节数
+	BytesOut int64
+	// Runes 转换过程中经过的Unicode字符数
+	Runes int64
+	// Lines 行数，最后一行没有换行符时也计入
+	Lines int64
+	// InvalidSequences 源数据中被替换为U+FFFD的非法字节序列数
+	InvalidSequences int64
+	// Unmappable 编码时目标字符集无法表示、按WithErrorPolicy或Handler替换、跳过或音译后继续转换的字符数。
+	// PolicyDefault下遇到这样的字符时转换返回错误，不计数；WithEncoder传入的编码器自行替换的字符同样不计数
+	Unmappable int64
+	// Sanitized 按WithSanitize的设置被删除或替换的字符数
+	Sanitized int64
+	// BOMFound 源数据是否以BOM开头
+	BOMFound bool
+	// BOMStripped BOM是否在转换过程中被去除
+	BOMStripped bool
+	// Newline 检测到的换行符风格
+	Newline NewlineStyle
+	// DetectedCharset 自动检测得到的源字符集，未启用自动检测时为空
+	DetectedCharset string
+}
+
+// countingReader 统计读取字节数，并记录源数据的前几个字节用于BOM检测
+type countingReader struct {
+	r     io.Reader
+	n     int64
+	head  [4]byte
+	nHead int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if c.nHead < len(c.head) {
+		c.nHead += copy(c.head[c.nHead:], p[:n])
+	}
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter 统计写入字节数
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// statsObserver 位于Unicode域（UTF-8）的透传Transformer，负责统计字符数、行数、换行符风格等信息
+type statsObserver struct {
+	stats *Stats
+	// countFFFD 为true时将U+FFFD计为非法序列（解码路径），否则统计非法的UTF-8序列（编码路径）
+	countFFFD bool
+	started   bool
+	finished  bool
+	pendingCR bool
+	lastRune  rune
+	lf        bool
+	crlf      bool
+	cr        bool
+}
+
+func newStatsObserver(stats *Stats, countFFFD bool) *statsObserver {
+	return &statsObserver{stats: stats, countFFFD: countFFFD}
+}
+
+func (o *statsObserver) Reset() {
+	*o = statsObserver{stats: o.stats, countFFFD: o.countFFFD}
+}
+
+func (o *statsObserver) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		r, size := rune(src[nSrc]), 1
+		if r >= utf8.RuneSelf {
+			if !atEOF && !utf8.FullRune(src[nSrc:]) {
+				err = transform.ErrShortSrc
+				break
+			}
+			r, size = utf8.DecodeRune(src[nSrc:])
+		}
+		if nDst+size > len(dst) {
+			err = transform.ErrShortDst
+			break
+		}
+		copy(dst[nDst:], src[nSrc:nSrc+size])
+		nDst += size
+		nSrc += size
+		o.observe(r, size)
+	}
+	if atEOF && err == nil {
+		o.finish()
+	}
+	return nDst, nSrc, err
+}
+
+func (o *statsObserver) observe(r rune, size int) {
+	st := o.stats
+	if r == utf8.RuneError && (o.countFFFD || size == 1) {
+		st.InvalidSequences++
+	}
+	if !o.started {
+		o.started = true
+		if r == '\uFEFF' {
+			st.BOMFound = true
+		}
+	}
+	st.Runes++
+	if o.pendingCR {
+		o.pendingCR = false
+		if r == '\n' {
+			o.crlf = true
+			o.lastRune = r
+			return
+		}
+		o.cr = true
+	}
+	switch r {
+	case '\n':
+		o.lf = true
+		st.Lines++
+	case '\r':
+		o.pendingCR = true
+		st.Lines++
+	}
+	o.lastRune = r
+}
+
+func (o *statsObserver) finish() {
+	if o.finished {
+		return
+	}
+	o.finished = true
+	st := o.stats
+	if o.pendingCR {
+		o.pendingCR = false
+		o.cr = true
+	}
+	if o.started && o.lastRune != '\n' && o.lastRune != '\r' {
+		st.Lines++
+	}
+	st.Newline = newlineStyleOf(o.lf, o.crlf, o.cr)
+}
+
+func newlineStyleOf(lf, crlf, cr bool) NewlineStyle {
+	count := 0
+	style := NewlineNone
+	if lf {
+		count++
+		style = NewlineLF
+	}
+	if crlf {
+		count++
+		style = NewlineCRLF
+	}
+	if cr {
+		count++
+		style = NewlineCR
+	}
+	if count > 1 {
+		return NewlineMixed
+	}
+	return style
+}
+
+// hasBOM 判断源数据开头是否为charset对应的BOM
+func hasBOM(charset string, head []byte) bool {
+	switch {
+	case charsetEquals(charset, UTF8):
+		return len(head) >= 3 && head[0] == 0xEF && head[1] == 0xBB && head[2] == 0xBF
+	case charsetEquals(charset, UTF16), charsetEquals(charset, UTF16BE), charsetEquals(charset, UTF16LE):
+		return len(head) >= 2 && (head[0] == 0xFE && head[1] == 0xFF || head[0] == 0xFF && head[1] == 0xFE)
+	case charsetEquals(charset, UTF32), charsetEquals(charset, UTF32BE), charsetEquals(charset, UTF32LE):
+		return bytes.HasPrefix(head, []byte{0x00, 0x00, 0xFE, 0xFF}) || bytes.HasPrefix(head, []byte{0xFF, 0xFE, 0x00, 0x00})
+	}
+	return false
+}
+
+// fixBOMStats 根据源数据开头的字节修正BOM相关统计信息。
+// 部分解码器（如UTF-16）会自行去除BOM，此时Unicode域中无法观察到BOM
+func fixBOMStats(stats *Stats, srcCharset string, head []byte) {
+	if stats.BOMFound || !hasBOM(srcCharset, head) {
+		return
+	}
+	stats.BOMFound = true
+	stats.BOMStripped = true
+}
+
+// decodeHandlerOf 返回t中包装的decodeHandler，不存在时返回nil
+func decodeHandlerOf(t transform.Transformer) *decodeHandler {
+	switch v := t.(type) {
+	case *decodeHandler:
+		return v
+	case *encoding.Decoder:
+		if v != nil {
+			h, _ := v.Transformer.(*decodeHandler)
+			return h
+		}
+	}
+	return nil
+}
+
+// encodeHandlerOf 返回t中包装的encodeHandler，不存在时返回nil
+func encodeHandlerOf(t transform.Transformer) *encodeHandler {
+	switch v := t.(type) {
+	case *encodeHandler:
+		return v
+	case *encoding.Encoder:
+		if v != nil {
+			h, _ := v.Transformer.(*encodeHandler)
+			return h
+		}
+	}
+	return nil
+}
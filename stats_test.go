@@ -0,0 +1,55 @@
+package charconv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeWithStats(t *testing.T) {
+	src := append([]byte{}, gbkData...)
+	src = append(src, '\r', '\n', 0xFF, 'a', '\r', '\n')
+	dest := MakeByteBuffer(0)
+	stats, err := DecodeWithStats(bytes.NewReader(src), dest, DecoderOf(GBK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.BytesIn != int64(len(src)) || stats.BytesOut != int64(dest.Len()) {
+		t.Fatal(stats)
+	}
+	if stats.Runes != 11 || stats.Lines != 2 || stats.InvalidSequences != 1 {
+		t.Fatal(stats)
+	}
+	if stats.Newline != NewlineCRLF || stats.BOMFound {
+		t.Fatal(stats)
+	}
+}
+
+func TestEncodeWithStats(t *testing.T) {
+	src := "\uFEFF" + utf8String + "\n" + utf8String + "\r\n" + utf8String
+	dest := MakeByteBuffer(0)
+	stats, err := EncodeWithStats(bytes.NewReader([]byte(src)), dest, EncoderOf(GB18030))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Lines != 3 || stats.Newline != NewlineMixed {
+		t.Fatal(stats)
+	}
+	if !stats.BOMFound || stats.BOMStripped {
+		t.Fatal(stats)
+	}
+}
+
+func TestConvertBetweenCharsetsWithStats(t *testing.T) {
+	src := []byte{0xFF, 0xFE, 'a', 0, '\r', 0, 'b', 0}
+	dest := MakeByteBuffer(0)
+	stats, err := ConvertBetweenCharsetsWithStats(bytes.NewReader(src), UTF16, dest, GBK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest.String() != "a\rb" {
+		t.Fatal(dest.String())
+	}
+	if !stats.BOMFound || !stats.BOMStripped || stats.Newline != NewlineCR || stats.Lines != 2 {
+		t.Fatal(stats)
+	}
+}
@@ -0,0 +1,478 @@
+package charconv
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/text/transform"
+)
+
+// errClosed 对已关闭的Reader、Writer进行读写
+var errClosed = errors.New("charconv: use of closed stream")
+
+// shiftTracker 跟踪ISO-2022-JP、HZ-GB-2312等有状态字符集的移位状态，用于判断数据是否在转义状态中结束
+type shiftTracker struct {
+	hz bool
+	// offset 已处理的源数据字节数
+	offset int64
+	// esc 正在解析的转义序列已读取的字节数
+	esc      int
+	escStart int64
+	escByte  byte
+	shifted  bool
+	// shiftOffset 最近一次进入非ASCII状态的转义序列在源数据中的偏移
+	shiftOffset int64
+}
+
+// newShiftTracker 返回srcCharset对应的shiftTracker，无状态的字符集返回nil
+func newShiftTracker(srcCharset string) *shiftTracker {
+	switch {
+	case charsetEquals(srcCharset, ISO2022JP):
+		return &shiftTracker{}
+	case EncodingOf(srcCharset) == EncodingOf("HZGB2312"):
+		return &shiftTracker{hz: true}
+	}
+	return nil
+}
+
+func (s *shiftTracker) reset() {
+	*s = shiftTracker{hz: s.hz}
+}
+
+// update 处理已被解码器消耗的源数据p
+func (s *shiftTracker) update(p []byte) {
+	for _, b := range p {
+		pos := s.offset
+		s.offset++
+		if s.hz {
+			s.updateHZ(b, pos)
+		} else {
+			s.updateISO2022(b, pos)
+		}
+	}
+}
+
+// updateISO2022 ESC ( B、ESC ( J切换到单字节状态，ESC ( I、ESC $ @、ESC $ B、ESC $ ( D切换到非ASCII状态
+func (s *shiftTracker) updateISO2022(b byte, pos int64) {
+	switch s.esc {
+	case 0:
+		if b == 0x1B {
+			s.esc, s.escStart = 1, pos
+		}
+		return
+	case 1:
+		if b == '(' || b == '$' {
+			s.esc, s.escByte = 2, b
+			return
+		}
+	case 2:
+		if s.escByte == '$' && b == '(' {
+			s.esc = 3
+			return
+		}
+		if s.escByte == '(' {
+			s.shift(b == 'I')
+		} else {
+			s.shift(true)
+		}
+	case 3:
+		s.shift(true)
+	}
+	s.esc = 0
+}
+
+// updateHZ ~{切换到GB2312状态，~}切换回ASCII状态
+func (s *shiftTracker) updateHZ(b byte, pos int64) {
+	if s.esc == 0 {
+		if b == '~' {
+			s.esc, s.escStart = 1, pos
+		}
+		return
+	}
+	s.esc = 0
+	switch b {
+	case '{':
+		s.shift(true)
+	case '}':
+		s.shift(false)
+	}
+}
+
+func (s *shiftTracker) shift(shifted bool) {
+	if shifted && !s.shifted {
+		s.shiftOffset = s.escStart
+	}
+	s.shifted = shifted
+}
+
+// stream Reader与Writer共用的转换状态
+type stream struct {
+	conv  *Converter
+	st    *state
+	t     transform.Transformer
+	shift *shiftTracker
+	// consumed 已被转换的源数据字节数
+	consumed int64
+	// partial 数据在字符中间结束，不完整字符的偏移为partialOffset
+	partial       bool
+	partialOffset int64
+}
+
+func newStream(charset string, opts []Option) (*stream, error) {
+	c, err := NewConverter(append([]Option{WithSourceCharset(charset)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	if c.decoder == nil && c.srcCharset == AutoDetect {
+		return nil, opError(c.op, "", unsupported(AutoDetect))
+	}
+	s := &stream{conv: c, shift: newShiftTracker(c.srcCharset)}
+	s.reset()
+	return s, nil
+}
+
+// reset 重置转换状态，必要时从Converter的池中重新取出state
+func (s *stream) reset() {
+	if s.st == nil {
+		s.st = s.conv.getState(s.conv.srcCharset)
+	}
+	s.t = s.st.begin(nil)
+	if s.t == nil {
+		s.t = transform.Nop
+	}
+	if s.shift != nil {
+		s.shift.reset()
+	}
+	s.consumed = 0
+	s.partial = false
+}
+
+// markPartial 记录剩余未转换的数据是不完整的字符
+func (s *stream) markPartial() {
+	s.partial = true
+	s.partialOffset = s.consumed
+}
+
+// transform 转换src并记录消耗的源数据
+func (s *stream) transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	nDst, nSrc, err = s.t.Transform(dst, src, atEOF)
+	if s.shift != nil {
+		s.shift.update(src[:nSrc])
+	}
+	s.consumed += int64(nSrc)
+	return nDst, nSrc, err
+}
+
+// truncated 返回数据在字符中间或转义状态中结束时的错误
+func (s *stream) truncated() error {
+	if s.partial {
+		return truncatedStream(s.partialOffset, false)
+	}
+	if s.shift != nil && s.shift.shifted {
+		return truncatedStream(s.shift.shiftOffset, true)
+	}
+	return nil
+}
+
+// release 将state放回Converter的池中
+func (s *stream) release() {
+	if s.st != nil {
+		s.conv.putState(s.st)
+		s.st = nil
+		s.t = nil
+	}
+}
+
+// Reader 从底层io.Reader读取charset编码的数据，转换后（默认为UTF-8）供读取
+type Reader struct {
+	*stream
+	r      io.Reader
+	src    []byte
+	dst    []byte
+	src0   int
+	src1   int
+	dst0   int
+	dst1   int
+	eof    bool
+	flush  bool
+	err    error
+	closed bool
+}
+
+// NewReader 创建从r读取charset编码数据的Reader，opts可设置目标字符集、错误策略等Converter选项。
+// charset不能为AutoDetect
+func NewReader(r io.Reader, charset string, opts ...Option) (*Reader, error) {
+	s, err := newStream(charset, opts)
+	if err != nil {
+		return nil, err
+	}
+	srcBuf, dstBuf := s.st.buffers(s.conv.bufferSize)
+	return &Reader{stream: s, r: r, src: srcBuf, dst: dstBuf}, nil
+}
+
+// Reset 丢弃所有状态，改为从r读取数据。已关闭的Reader也可以通过Reset重新使用
+func (r *Reader) Reset(reader io.Reader) {
+	closed := r.st == nil
+	r.stream.reset()
+	if closed {
+		r.src, r.dst = r.st.buffers(r.conv.bufferSize)
+	}
+	r.r = reader
+	r.src0, r.src1, r.dst0, r.dst1 = 0, 0, 0, 0
+	r.eof, r.flush, r.err, r.closed = false, false, nil, false
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for {
+		if r.dst0 < r.dst1 {
+			n := copy(p, r.dst[r.dst0:r.dst1])
+			r.dst0 += n
+			return n, nil
+		}
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.step()
+	}
+}
+
+// WriteTo 实现io.WriterTo，将转换结果直接写入w，不经过调用者提供的缓冲区
+func (r *Reader) WriteTo(w io.Writer) (written int64, err error) {
+	for {
+		if r.dst0 < r.dst1 {
+			n, err := w.Write(r.dst[r.dst0:r.dst1])
+			written += int64(n)
+			r.dst0 += n
+			if err != nil {
+				return written, err
+			}
+		}
+		if r.err != nil {
+			if r.err == io.EOF {
+				return written, nil
+			}
+			return written, r.err
+		}
+		r.step()
+	}
+}
+
+// step 执行一次转换或读取，转换结果保存在r.dst[r.dst0:r.dst1]中
+func (r *Reader) step() {
+	if r.closed {
+		r.err = errClosed
+		return
+	}
+	if r.src0 < r.src1 || r.eof {
+		nDst, nSrc, err := r.transform(r.dst, r.src[r.src0:r.src1], r.flush)
+		r.src0 += nSrc
+		r.dst0, r.dst1 = 0, nDst
+		switch {
+		case err == nil:
+			if r.flush {
+				r.err = io.EOF
+				return
+			}
+			if r.eof {
+				// 剩余数据已全部转换，下一步以atEOF为true刷新
+				r.flush = true
+				return
+			}
+		case err == transform.ErrShortDst && (nDst > 0 || nSrc > 0):
+			return
+		case err == transform.ErrShortSrc && !r.flush:
+			if r.eof {
+				// 底层数据已读完，剩余的不完整字符在刷新时处理
+				r.markPartial()
+				r.flush = true
+				return
+			}
+			if r.src1-r.src0 == len(r.src) {
+				r.err = err
+				return
+			}
+		default:
+			r.err = opError(r.conv.op, "", err)
+			return
+		}
+		if nDst > 0 {
+			return
+		}
+	}
+
+	if r.src0 > 0 {
+		r.src1 = copy(r.src, r.src[r.src0:r.src1])
+		r.src0 = 0
+	}
+	n, err := r.r.Read(r.src[r.src1:])
+	r.src1 += n
+	if err == io.EOF {
+		r.eof = true
+	} else if err != nil {
+		r.err = err
+	}
+}
+
+// Close 释放内部资源，不会关闭底层的io.Reader。
+// 已读取到数据末尾且数据在字符中间或ISO-2022、HZ的转义状态中结束时返回ErrTruncatedStream
+func (r *Reader) Close() error {
+	if r.closed {
+		return nil
+	}
+	var err error
+	if r.err == io.EOF {
+		err = opError(r.conv.op, "", r.truncated())
+	}
+	r.closed = true
+	r.release()
+	r.src, r.dst = nil, nil
+	r.src0, r.src1, r.dst0, r.dst1 = 0, 0, 0, 0
+	return err
+}
+
+// Writer 将写入的charset编码数据转换后（默认为UTF-8）写入底层io.Writer。
+// 跨越多次Write调用的不完整字符会被缓存，直到后续数据补全
+type Writer struct {
+	*stream
+	w       io.Writer
+	pending []byte
+	dst     []byte
+	src     []byte
+	err     error
+	closed  bool
+}
+
+// NewWriter 创建向w写入的Writer，写入Writer的数据为charset编码，opts可设置目标字符集、错误策略等Converter选项。
+// charset不能为AutoDetect
+func NewWriter(w io.Writer, charset string, opts ...Option) (*Writer, error) {
+	s, err := newStream(charset, opts)
+	if err != nil {
+		return nil, err
+	}
+	srcBuf, dstBuf := s.st.buffers(s.conv.bufferSize)
+	return &Writer{stream: s, w: w, src: srcBuf, dst: dstBuf}, nil
+}
+
+// Reset 丢弃所有状态（包括未写出的不完整字符），改为向w写入。已关闭的Writer也可以通过Reset重新使用
+func (w *Writer) Reset(writer io.Writer) {
+	closed := w.st == nil
+	w.stream.reset()
+	if closed {
+		w.src, w.dst = w.st.buffers(w.conv.bufferSize)
+	}
+	w.w = writer
+	w.pending = w.pending[:0]
+	w.err, w.closed = nil, false
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if len(w.pending) == 0 {
+		n, err := w.write(p, false)
+		if err == nil {
+			// 不完整的字符留待后续数据补全
+			w.pending = append(w.pending, p[n:]...)
+			n = len(p)
+		}
+		return n, err
+	}
+
+	w.pending = append(w.pending, p...)
+	n, err := w.write(w.pending, false)
+	if err != nil {
+		return 0, err
+	}
+	w.pending = w.pending[:copy(w.pending, w.pending[n:])]
+	return len(p), nil
+}
+
+// write 转换src并写入底层io.Writer，返回消耗的src字节数。atEOF为false时末尾不完整的字符不会被消耗
+func (w *Writer) write(src []byte, atEOF bool) (n int, err error) {
+	for {
+		nDst, nSrc, terr := w.transform(w.dst, src[n:], atEOF)
+		n += nSrc
+		if nDst > 0 {
+			if _, err = w.w.Write(w.dst[:nDst]); err != nil {
+				w.err = err
+				return n, err
+			}
+		}
+		switch {
+		case terr == nil, terr == transform.ErrShortSrc && !atEOF:
+			return n, nil
+		case terr == transform.ErrShortDst && (nDst > 0 || nSrc > 0):
+			continue
+		}
+		w.err = opError(w.conv.op, "", terr)
+		return n, w.err
+	}
+}
+
+// ReadFrom 实现io.ReaderFrom，从r读取数据直到io.EOF，使用内部缓冲区转换后写入底层io.Writer
+func (w *Writer) ReadFrom(r io.Reader) (read int64, err error) {
+	if w.closed {
+		return 0, errClosed
+	}
+	// 先处理此前缓存的不完整字符
+	n := copy(w.src, w.pending)
+	w.pending = w.pending[:0]
+	for {
+		if w.err != nil {
+			return read, w.err
+		}
+		m, rerr := r.Read(w.src[n:])
+		read += int64(m)
+		n += m
+		consumed, err := w.write(w.src[:n], false)
+		if err != nil {
+			return read, err
+		}
+		if consumed == 0 && n == len(w.src) {
+			w.err = opError(w.conv.op, "", transform.ErrShortSrc)
+			return read, w.err
+		}
+		n = copy(w.src, w.src[consumed:n])
+		if rerr == io.EOF {
+			w.pending = append(w.pending, w.src[:n]...)
+			return read, nil
+		}
+		if rerr != nil {
+			w.pending = append(w.pending, w.src[:n]...)
+			return read, rerr
+		}
+	}
+}
+
+// Close 转换并写出剩余数据，释放内部资源，不会关闭底层的io.Writer。
+// 数据在字符中间或ISO-2022、HZ的转义状态中结束时返回ErrTruncatedStream
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer func() {
+		w.release()
+		w.src, w.dst = nil, nil
+	}()
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.pending) > 0 {
+		w.markPartial()
+	}
+	truncated := opError(w.conv.op, "", w.truncated())
+	_, err := w.write(w.pending, true)
+	w.pending = w.pending[:0]
+	if truncated != nil && err != nil {
+		return errors.Join(truncated, err)
+	}
+	if err != nil {
+		return err
+	}
+	return truncated
+}
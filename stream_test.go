@@ -0,0 +1,135 @@
+package charconv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReader(t *testing.T) {
+	r, err := NewReader(iotest.OneByteReader(bytes.NewReader(gbkData)), GBK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(dest, utf8Data) {
+		t.Fatal(string(dest), err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close后通过Reset重新使用，WriteTo直接写入目标
+	r.Reset(bytes.NewReader(gbkData))
+	buffer := MakeByteBuffer(0)
+	if _, err = io.Copy(buffer, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffer.Bytes(), utf8Data) {
+		t.Fatal(buffer.String())
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(gbkData[:len(gbkData)-1]), GBK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	err = r.Close()
+	var target ErrTruncatedStream
+	if !errors.As(err, &target) || target.Escape || target.Offset != int64(len(gbkData)-2) || !errors.Is(err, ErrTruncated) {
+		t.Fatal(err)
+	}
+}
+
+func TestReaderEscapeState(t *testing.T) {
+	src, err := EncodeStringToBytesWithCharset("abcこんにちは", 0, ISO2022JP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 去掉末尾切换回ASCII的转义序列
+	src = bytes.TrimSuffix(src, []byte("\x1b(B"))
+	r, err := NewReader(bytes.NewReader(src), ISO2022JP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, err := io.ReadAll(r)
+	if err != nil || string(dest) != "abcこんにちは" {
+		t.Fatal(string(dest), err)
+	}
+	err = r.Close()
+	var target ErrTruncatedStream
+	if !errors.As(err, &target) || !target.Escape || target.Offset != 3 {
+		t.Fatal(err)
+	}
+
+	r2, err := NewReader(strings.NewReader("ab~{<:Ky"), "HZGB2312")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadAll(r2); err != nil {
+		t.Fatal(err)
+	}
+	if err = r2.Close(); !errors.As(err, &target) || !target.Escape || target.Offset != 2 {
+		t.Fatal(err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	buffer := MakeByteBuffer(0)
+	w, err := NewWriter(buffer, GBK, WithTargetCharset(EUCJP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 逐字节写入，多字节字符跨越多次Write调用
+	for i := range gbkData {
+		n, err := w.Write(gbkData[i : i+1])
+		if n != 1 || err != nil {
+			t.Fatal(n, err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffer.Bytes(), eucjpData) {
+		t.Fatal(buffer.Bytes())
+	}
+
+	// ReadFrom
+	buffer.Reset()
+	w.Reset(buffer)
+	if _, err = io.Copy(w, iotest.HalfReader(bytes.NewReader(gbkData))); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil || !bytes.Equal(buffer.Bytes(), eucjpData) {
+		t.Fatal(buffer.Bytes(), err)
+	}
+}
+
+func TestWriterTruncated(t *testing.T) {
+	buffer := MakeByteBuffer(0)
+	w, err := NewWriter(buffer, UTF8, WithTargetCharset(GBK), WithErrorPolicy(PolicyReplace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(utf8Data[:len(utf8Data)-1]); err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	var target ErrTruncatedStream
+	if !errors.As(err, &target) || target.Offset != int64(len(utf8Data)-3) {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(utf8Data); err == nil {
+		t.Fatal("write after close should fail")
+	}
+}
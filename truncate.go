@@ -0,0 +1,181 @@
+package charconv
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+)
+
+// encodedSizer 逐字符累计字符串以某个字符集编码后的长度
+type encodedSizer struct {
+	rc *RuneCodec
+	// enc 有状态字符集使用的编码器，无状态字符集通过码表计算长度
+	enc *encoding.Encoder
+	// flushLen 处于非ASCII状态时恢复初始状态所需的转义序列长度
+	flushLen int
+	shifted  bool
+	n        int
+}
+
+// makeEncodedSizer 创建rc对应的encodedSizer，使用完毕后需调用release
+func makeEncodedSizer(rc *RuneCodec) encodedSizer {
+	z := encodedSizer{rc: rc}
+	if rc.kind == kindStateful {
+		z.enc = rc.encoder()
+		if s := newShiftTracker(rc.charset); s != nil {
+			// ISO-2022-JP以ESC ( B、HZ-GB-2312以~}恢复ASCII状态
+			z.flushLen = 3
+			if s.hz {
+				z.flushLen = 2
+			}
+		}
+	}
+	return z
+}
+
+// release 将编码器放回RuneCodec的池中
+func (z *encodedSizer) release() {
+	if z.enc != nil {
+		z.rc.putEncoder(z.enc)
+		z.enc = nil
+	}
+}
+
+func (z *encodedSizer) reset() {
+	if z.enc != nil {
+		z.enc.Reset()
+	}
+	z.shifted = false
+	z.n = 0
+}
+
+// add 累计s中偏移为off处的字符r编码后的长度
+func (z *encodedSizer) add(s string, off int, r rune, size int) error {
+	if r == utf8.RuneError && size == 1 {
+		return invalidSequence(int64(off), []byte(s[off:off+1]))
+	}
+	if z.enc == nil {
+		l := z.rc.RuneLen(r)
+		if l < 0 {
+			return unmappableRune(int64(off), r)
+		}
+		z.n += l
+		return nil
+	}
+
+	var buf [16]byte
+	n, _, err := z.enc.Transform(buf[:], unsafeBytes(s[off:off+size]), false)
+	if err != nil {
+		return unmappableRune(int64(off), r)
+	}
+	z.n += n
+	// 有状态的编码器遇到ASCII字符时切换回ASCII状态，遇到其他字符时切换到相应的非ASCII状态
+	z.shifted = r >= utf8.RuneSelf
+	return nil
+}
+
+// size 返回已累计的字符编码后（包括恢复初始状态的转义序列）的长度
+func (z *encodedSizer) size() int {
+	if z.shifted {
+		return z.n + z.flushLen
+	}
+	return z.n
+}
+
+// encodedSize 返回s以z的字符集单独编码后的长度
+func (z *encodedSizer) encodedSize(s string) (int, error) {
+	z.reset()
+	for i, r := range s {
+		if err := z.add(s, i, r, runeSize(s, i, r)); err != nil {
+			return 0, err
+		}
+	}
+	return z.size(), nil
+}
+
+// TruncateEncoded 截断UTF-8字符串s，使其以charset编码后不超过n字节，不会截断字符或ISO-2022、HZ的转义序列。
+// 发生截断时在结尾追加ellipsis（可为空），ellipsis本身超过n字节时不追加。
+// 对有状态的字符集，按截断结果与ellipsis分别编码的长度之和计算，实际编码结果不会更长。
+// s中存在非法UTF-8序列或无法映射的字符时返回错误
+func TruncateEncoded(s string, charset string, n int, ellipsis string) (string, error) {
+	rc, err := RuneCodecOf(charset)
+	if err != nil {
+		return "", opError(OpEncode, "", err)
+	}
+	z := makeEncodedSizer(rc)
+	defer z.release()
+	ellipsisLen, err := z.encodedSize(ellipsis)
+	if err != nil {
+		return "", opError(OpEncode, "", err)
+	}
+
+	z.reset()
+	// plain为不追加ellipsis时可保留的前缀长度，withEllipsis为追加ellipsis时可保留的前缀长度
+	plain, withEllipsis := 0, -1
+	if ellipsisLen <= n {
+		withEllipsis = 0
+	}
+	for i, r := range s {
+		size := runeSize(s, i, r)
+		if err := z.add(s, i, r, size); err != nil {
+			return "", opError(OpEncode, "", err)
+		}
+		if z.size() > n {
+			if withEllipsis >= 0 {
+				return s[:withEllipsis] + ellipsis, nil
+			}
+			return s[:plain], nil
+		}
+		plain = i + size
+		if z.size()+ellipsisLen <= n {
+			withEllipsis = plain
+		}
+	}
+	return s, nil
+}
+
+// SplitEncoded 将UTF-8字符串s切分为若干段，每段以charset单独编码后不超过n字节，不会切分字符或ISO-2022、HZ的转义序列。
+// 单个字符编码后超过n字节时返回ErrSizeLimit
+func SplitEncoded(s string, charset string, n int) ([]string, error) {
+	rc, err := RuneCodecOf(charset)
+	if err != nil {
+		return nil, opError(OpEncode, "", err)
+	}
+	z := makeEncodedSizer(rc)
+	defer z.release()
+	var chunks []string
+	start := 0
+	for i, r := range s {
+		size := runeSize(s, i, r)
+		if err := z.add(s, i, r, size); err != nil {
+			return nil, opError(OpEncode, "", err)
+		}
+		if z.size() <= n {
+			continue
+		}
+		if i > start {
+			chunks = append(chunks, s[start:i])
+			start = i
+			z.reset()
+			if err := z.add(s, i, r, size); err != nil {
+				return nil, opError(OpEncode, "", err)
+			}
+		}
+		if z.size() > n {
+			return nil, opError(OpEncode, "", sizeLimit(LimitOutput, int64(n)))
+		}
+	}
+	if start < len(s) {
+		chunks = append(chunks, s[start:])
+	}
+	return chunks, nil
+}
+
+// runeSize 返回s中偏移为i处的字符r的长度，非法UTF-8序列的长度为1
+func runeSize(s string, i int, r rune) int {
+	if r == utf8.RuneError {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		return size
+	}
+	return utf8.RuneLen(r)
+}
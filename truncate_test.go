@@ -0,0 +1,71 @@
+package charconv
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTruncateEncoded(t *testing.T) {
+	cases := []struct {
+		s, charset string
+		n          int
+		ellipsis   string
+		want       string
+	}{
+		{"你好世界", GBK, 5, "", "你好"},
+		{"你好世界", GBK, 8, "", "你好世界"},
+		{"你好世界", GBK, 7, "...", "你好..."},
+		{"你好世界", GBK, 6, "…", "你好…"},
+		{"ab你好", UTF8, 4, "", "ab"},
+		{"abc", GBK, 2, "......", "ab"},
+		// ESC $ B + 日本 + ESC ( B 共10字节
+		{"日本語", ISO2022JP, 10, "", "日本"},
+		{"日本語", ISO2022JP, 9, "", "日"},
+		{"a日本", ISO2022JP, 4, "", "a"},
+	}
+	for _, c := range cases {
+		got, err := TruncateEncoded(c.s, c.charset, c.n, c.ellipsis)
+		if err != nil || got != c.want {
+			t.Fatal(c.s, c.charset, c.n, got, err)
+		}
+		encoded, _ := EncodeStringToBytesWithCharset(got, 0, c.charset)
+		if len(encoded) > c.n {
+			t.Fatal(c.s, c.charset, c.n, len(encoded))
+		}
+	}
+
+	if _, err := TruncateEncoded("a你", ISO88591, 10, ""); !errors.Is(err, ErrUnmappable) {
+		t.Fatal(err)
+	}
+	if _, err := TruncateEncoded("a\xff", GBK, 10, ""); !errors.Is(err, ErrInvalidSequence) {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitEncoded(t *testing.T) {
+	text := strings.Repeat("短信内容abc", 20)
+	for _, charset := range []string{GBK, UTF16BE, ISO2022JP, UTF8} {
+		chunks, err := SplitEncoded(text, charset, 20)
+		if err != nil {
+			t.Fatal(charset, err)
+		}
+		if strings.Join(chunks, "") != text {
+			t.Fatal(charset, chunks)
+		}
+		for i, chunk := range chunks {
+			encoded, err := EncodeStringToBytesWithCharset(chunk, 0, charset)
+			if err != nil || len(encoded) > 20 || i < len(chunks)-1 && len(encoded) < 16 {
+				t.Fatal(charset, chunk, len(encoded), err)
+			}
+		}
+	}
+
+	var limit ErrSizeLimit
+	if _, err := SplitEncoded("日本", ISO2022JP, 4); !errors.As(err, &limit) || limit.Limit != 4 {
+		t.Fatal(err)
+	}
+	if chunks, err := SplitEncoded("", GBK, 4); err != nil || len(chunks) != 0 {
+		t.Fatal(chunks, err)
+	}
+}